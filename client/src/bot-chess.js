@@ -1,71 +1,141 @@
 let board = null;
-let currentFen = 'rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1'; // Initialize with full starting FEN
+let session = null; // State of the game held by the server
 
 const config = {
     draggable: true,
     position: 'start',
+    onDragStart: handleDragStart,
     onDrop: handlePlayerMove
 };
 
 document.addEventListener('DOMContentLoaded', function() {
     board = Chessboard('myBoard', config);
+    document.getElementById('newGameBtn').addEventListener('click', newGame);
+    document.getElementById('resignBtn').addEventListener('click', resign);
+    document.getElementById('hintBtn').addEventListener('click', showHint);
+    document.getElementById('threatsBtn').addEventListener('click', showThreats);
+    loadLevels().then(newGame);
 });
 
-async function handlePlayerMove(source, target) {
-    const playerMove = `${source}${target}`;
-    const oldFen = currentFen; // Store the FEN before the move
-
-    // Get the current board position object from the board instance
-    const currentPositionObj = board.position();
+async function api(method, url, body) {
+    const token = localStorage.getItem('jwtToken');
+    if (!token) {
+        window.location.href = '/login';
+        return null;
+    }
 
-    const sourceRank = parseInt(source[1]);
-    const targetRank = parseInt(target[1]);
-    const pieceOnSource = currentPositionObj[source]; // Get piece from the position object
+    const response = await fetch(url, {
+        method: method,
+        headers: {
+            'Content-Type': 'application/json',
+            'Authorization': `Bearer ${token}`
+        },
+        body: body ? JSON.stringify(body) : undefined
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        console.error(data.error);
+        return null;
+    }
+    return data;
+}
 
-    let promotionPiece = ''; // Default to no promotion
+async function loadLevels() {
+    const data = await api('GET', '/api/bot/levels');
+    if (!data) {
+        return;
+    }
+    const select = document.getElementById('levelSelect');
+    data.levels.forEach(level => {
+        const option = document.createElement('option');
+        option.value = level.id;
+        option.textContent = `${level.id}. ${level.name}`;
+        option.selected = level.id === data.default;
+        select.appendChild(option);
+    });
+}
 
-    // Check for pawn promotion
-    if (pieceOnSource === 'wP' && sourceRank === 7 && targetRank === 8) {
-        promotionPiece = promptForPromotion();
-    } else if (pieceOnSource === 'bP' && sourceRank === 2 && targetRank === 1) {
-        promotionPiece = promptForPromotion();
+async function newGame() {
+    const data = await api('POST', '/api/bot/games', {
+        level: parseInt(document.getElementById('levelSelect').value, 10) || 0,
+        color: document.getElementById('colorSelect').value
+    });
+    if (data) {
+        board.orientation(data.playerColor);
+        updateGame(data);
     }
+}
 
-    // If promotion was cancelled or invalid, snapback
-    if (promotionPiece === null || (promotionPiece !== '' && !['q', 'r', 'b', 'n'].includes(promotionPiece.toLowerCase()))) {
-        board.position(oldFen);
+async function resign() {
+    if (!session || session.result) {
         return;
     }
+    const data = await api('POST', `/api/bot/games/${session.id}/resign`);
+    if (data) {
+        updateGame(data);
+    }
+}
 
-    console.log('Sending FEN:', currentFen); // Debugging line
+async function showHint() {
+    if (!session || session.result) {
+        return;
+    }
+    const data = await api('GET', `/api/hint?game=${session.id}`);
+    if (data) {
+        document.getElementById('advice').textContent = `Hint: ${data.san} (${data.reason.replace(/_/g, ' ')})`;
+    }
+}
 
-    const token = localStorage.getItem('jwtToken');
-    if (!token) {
-        window.location.href = '/login';
+async function showThreats() {
+    if (!session || session.result) {
         return;
     }
+    const data = await api('GET', `/api/threats?game=${session.id}`);
+    if (!data) {
+        return;
+    }
+    let text = 'No immediate threats.';
+    if (data.inCheck) {
+        text = 'You are in check!';
+    } else if (data.threats.length > 0) {
+        text = 'Threats: ' + data.threats.map(t => `${t.san} (${t.reason.replace(/_/g, ' ')})`).join(', ');
+    }
+    document.getElementById('advice').textContent = text;
+}
 
-    const response = await fetch(`/api/bot/move`, {
-        method: 'POST',
-        headers: {
-            'Content-Type': 'application/json',
-            'Authorization': `Bearer ${token}`
-        },
-        body: JSON.stringify({
-            currentFen: currentFen,
-            playerMove: playerMove,
-            promotionPiece: promotionPiece // Send promotion choice
-        })
-    });
+function handleDragStart(source, piece) {
+    // Only the player's own pieces can be moved, and only on their turn
+    if (!session || session.result) {
+        return false;
+    }
+    const turn = session.fen.split(' ')[1];
+    return turn === session.playerColor[0] && piece[0] === turn;
+}
 
-    if (!response.ok) {
+function handlePlayerMove(source, target) {
+    let promotionPiece = '';
+
+    // Check for pawn promotion
+    const piece = board.position()[source];
+    if ((piece === 'wP' && source[1] === '7' && target[1] === '8') ||
+        (piece === 'bP' && source[1] === '2' && target[1] === '1')) {
+        promotionPiece = promptForPromotion();
+        if (promotionPiece === null) {
+            return 'snapback';
+        }
+    }
+
+    sendMove(`${source}${target}${promotionPiece}`);
+}
+
+async function sendMove(move) {
+    const data = await api('POST', `/api/bot/games/${session.id}/move`, { move: move });
+    if (!data) {
         console.error("Invalid move!");
-        board.position(oldFen);
+        board.position(session.fen);
         return;
     }
-
-    const data = await response.json();
-    handleBackendResponse(data);
+    updateGame(data);
 }
 
 function promptForPromotion() {
@@ -79,11 +149,15 @@ function promptForPromotion() {
     return null; // User cancelled or entered invalid input
 }
 
-function handleBackendResponse(data) {
-    currentFen = data.newFen; // newFen from backend should be a full FEN
-    board.position(currentFen);
+function updateGame(data) {
+    session = data;
+    document.getElementById('advice').textContent = '';
+    board.position(session.fen);
+
+    const status = session.gameStatus.replace(/_/g, ' ');
+    document.getElementById('status').textContent = session.result ? `${status} (${session.result})` : status;
 
-    if (data.gameStatus != 'in_progress') {
-        alert(`Game Over: ${data.gameStatus}`)
+    if (session.result) {
+        setTimeout(() => alert(`Game Over: ${status}`), 300);
     }
-}
\ No newline at end of file
+}
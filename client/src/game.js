@@ -3,6 +3,23 @@ document.addEventListener('DOMContentLoaded', function() {
     let socket = null;
     let myColor = 'spectator';
     let currentFen = 'start';
+    let gameInProgress = false;
+    let reconnectAttempts = 0;
+    const maxReconnectAttempts = 10;
+
+    // Version of the WebSocket protocol this page speaks, and the actions of
+    // the requests still waiting for an ack or error by request ID
+    const protocolVersion = 1;
+    let nextRequestID = 1;
+    const pendingRequests = new Map();
+    let roomClosedReason = '';
+
+    // Side to move in the last game state, and our queued premove
+    let sideToMove = 'w';
+    let premove = null;
+    let isHost = false;
+    let hostedRoom = false; // Ranked rooms and rooms of accepted seeks have no host and no lobby
+    const mutedUsers = new Set();
 
     // --- DOM Elements ---
     const lobbyContainer = document.getElementById('lobbyContainer');
@@ -11,9 +28,51 @@ document.addEventListener('DOMContentLoaded', function() {
     const hostControls = document.getElementById('hostControls');
     const startGameBtn = document.getElementById('startGameBtn');
     const readyBtn = document.getElementById('readyBtn');
+    const botLevelSelect = document.getElementById('botLevelSelect');
+    const addBotBtn = document.getElementById('addBotBtn');
     const statusEl = document.getElementById('status');
     const fenEl = document.getElementById('fen');
+    const capturedEl = document.getElementById('captured');
+    const moveListEl = document.getElementById('moveList');
     const roomIDDisplay = document.getElementById('roomIDDisplay'); // New element
+    const engineInfoEl = document.getElementById('engineInfo');
+    const stopSearchBtn = document.getElementById('stopSearchBtn');
+    const analyzeBtn = document.getElementById('analyzeBtn');
+    const timeControlSelect = document.getElementById('timeControlSelect');
+    const resignBtn = document.getElementById('resignBtn');
+    const offerDrawBtn = document.getElementById('offerDrawBtn');
+    const abortBtn = document.getElementById('abortBtn');
+    const drawOfferEl = document.getElementById('drawOffer');
+    const drawOfferTextEl = document.getElementById('drawOfferText');
+    const acceptDrawBtn = document.getElementById('acceptDrawBtn');
+    const declineDrawBtn = document.getElementById('declineDrawBtn');
+    const takebackBtn = document.getElementById('takebackBtn');
+    const takebackRequestEl = document.getElementById('takebackRequest');
+    const takebackRequestTextEl = document.getElementById('takebackRequestText');
+    const acceptTakebackBtn = document.getElementById('acceptTakebackBtn');
+    const declineTakebackBtn = document.getElementById('declineTakebackBtn');
+    const connectionNoticeEl = document.getElementById('connectionNotice');
+    const connectionNoticeTextEl = document.getElementById('connectionNoticeText');
+    const claimVictoryBtn = document.getElementById('claimVictoryBtn');
+    const clocksEl = document.getElementById('clocks');
+    const whiteClockEl = document.getElementById('whiteClock');
+    const blackClockEl = document.getElementById('blackClock');
+    const rematchEl = document.getElementById('rematch');
+    const rematchTextEl = document.getElementById('rematchText');
+    const offerRematchBtn = document.getElementById('offerRematchBtn');
+    const declineRematchBtn = document.getElementById('declineRematchBtn');
+    const chatChannelEl = document.getElementById('chatChannel');
+    const chatMessagesEl = document.getElementById('chatMessages');
+    const chatForm = document.getElementById('chatForm');
+    const chatInput = document.getElementById('chatInput');
+
+    // Last clock state from the server and when it arrived
+    let clock = null;
+    let clockReceivedAt = 0;
+
+    // Version of the last game state, to notice missed updates
+    let stateVersion = 0;
+    const pieceSymbols = { p: '♟', n: '♞', b: '♝', r: '♜', q: '♛', P: '♙', N: '♘', B: '♗', R: '♖', Q: '♕' };
 
     // --- WebSocket Connection ---
     function connect() {
@@ -38,8 +97,23 @@ document.addEventListener('DOMContentLoaded', function() {
         const wsURL = `${protocol}${window.location.host}/ws/game/${roomID}?token=${token}`;
         socket = new WebSocket(wsURL);
 
-        socket.onopen = () => console.log('WebSocket connection established');
-        socket.onclose = () => statusEl.textContent = 'Disconnected';
+        socket.onopen = () => {
+            console.log('WebSocket connection established');
+            reconnectAttempts = 0;
+            // The server expects a hello before anything else
+            socket.send(JSON.stringify({ action: 'hello', payload: { protocol_version: protocolVersion } }));
+        };
+        socket.onclose = (event) => {
+            // The server holds our seat for a while, so a game connection
+            // that dropped without the server closing it (code 1006) is retried
+            if (event.code === 1006 && gameInProgress && reconnectAttempts < maxReconnectAttempts) {
+                reconnectAttempts++;
+                statusEl.textContent = 'Reconnecting...';
+                setTimeout(connect, 2000);
+                return;
+            }
+            statusEl.textContent = roomClosedReason || 'Disconnected';
+        };
         socket.onerror = (error) => console.error('WebSocket error:', error);
         socket.onmessage = (event) => {
             const msg = JSON.parse(event.data);
@@ -59,19 +133,97 @@ document.addEventListener('DOMContentLoaded', function() {
                 console.log('Updating game view. FEN:', message.payload.fen);
                 updateGameView(message.payload);
                 break;
-            case 'error':
+            case 'hello':
+                console.log('Server speaks protocol version', message.payload.protocol_version);
+                break;
+            case 'ack':
+                pendingRequests.delete(message.request_id);
+                break;
+            case 'error': {
                 // Error messages are now less intrusive
-                console.warn('Server error:', message.payload.message);
+                const action = pendingRequests.get(message.request_id);
+                pendingRequests.delete(message.request_id);
+                console.warn(`Server error (${message.payload.code}${action ? `, ${action}` : ''}):`, message.payload.message);
                 if (board && board.fen() !== currentFen) {
                     board.position(currentFen, false);
                 }
+                if (['muted', 'rate_limited'].includes(message.payload.code) || action === 'chat') {
+                    appendChatNotice(message.payload.message);
+                }
+                if (message.payload.code === 'unsupported_version') {
+                    alert('This page is out of date. Please reload it.');
+                }
+                break;
+            }
+            case 'premove_queued':
+                showPremove(message.payload);
+                break;
+            case 'premove_cancelled':
+                showPremove(null);
+                break;
+            case 'premove_rejected':
+                console.warn('Premove discarded:', message.payload.reason);
+                showPremove(null);
+                break;
+            case 'room_closed':
+                gameInProgress = false;
+                roomClosedReason = message.payload.reason;
+                statusEl.textContent = roomClosedReason;
                 break;
             case 'player_assigned':
                 myColor = message.payload.color;
+                chatChannelEl.textContent = myColor === 'spectator' ? '(spectators)' : '(players)';
                 board.orientation(myColor === 'white' ? 'white' : 'black');
                 // log to the console the color they got
                 console.log('Assigned color:', myColor);
                 break;
+            case 'engine_info':
+                updateEngineInfo(message.payload);
+                break;
+            case 'draw_offer':
+                showDrawOffer(message.payload.color);
+                break;
+            case 'draw_declined':
+                showDrawOffer('');
+                break;
+            case 'takeback_request':
+                showTakebackRequest(message.payload.color);
+                break;
+            case 'takeback_declined':
+                showTakebackRequest('');
+                break;
+            case 'player_disconnected':
+                showConnectionNotice(`${message.payload.color} lost the connection.`, message.payload.grace_ms, false);
+                break;
+            case 'player_reconnected':
+                showConnectionNotice('');
+                break;
+            case 'disconnect_timeout':
+                showConnectionNotice(`${message.payload.color} did not come back.`, 0, message.payload.color !== myColor);
+                break;
+            case 'game_over':
+                setTimeout(() => alert(`Game Over: ${message.payload.result} (${message.payload.termination.replace(/_/g, ' ')})`), 300);
+                break;
+            case 'rematch_offer':
+                showRematch(message.payload.color);
+                break;
+            case 'rematch_declined':
+                showRematch('');
+                break;
+            case 'chat':
+                appendChatMessage(message.payload);
+                break;
+            case 'chat_muted':
+                mutedUsers.add(message.payload.user_id);
+                appendChatNotice(`User ${message.payload.user_id} was muted.`);
+                break;
+            case 'chat_unmuted':
+                mutedUsers.delete(message.payload.user_id);
+                appendChatNotice(`User ${message.payload.user_id} can chat again.`);
+                break;
+            case 'analysis_complete':
+                engineInfoEl.textContent += message.payload.stopped ? ' (stopped)' : ' (done)';
+                break;
             default:
                 console.log('Unknown message action:', message.action);
         }
@@ -79,7 +231,9 @@ document.addEventListener('DOMContentLoaded', function() {
 
     function sendMessage(action, payload = {}) {
         if (socket && socket.readyState === WebSocket.OPEN) {
-            socket.send(JSON.stringify({ action, payload }));
+            const requestID = String(nextRequestID++);
+            pendingRequests.set(requestID, action);
+            socket.send(JSON.stringify({ action, request_id: requestID, payload }));
         }
     }
 
@@ -90,14 +244,33 @@ document.addEventListener('DOMContentLoaded', function() {
             lobbyContainer.classList.remove('hidden');
             gameContainer.classList.add('hidden');
 
+            isHost = state.is_host;
+            hostedRoom = true;
             hostControls.classList.toggle('hidden', !state.is_host);
             readyBtn.classList.toggle('hidden', state.is_host);
 
             let statusText = `Players: ${state.player_count}/2.`;
             statusText += ` You are ${state.is_host ? 'the Host' : 'the Guest'}.`;
-            lobbyStatus.innerHTML = `${statusText}<br>Guest is ${state.guest_ready ? 'Ready' : 'Not Ready'}.`;
+            if (state.guest_is_bot) {
+                lobbyStatus.innerHTML = `${statusText}<br>Guest is the computer (level ${state.bot_level}).`;
+            } else {
+                lobbyStatus.innerHTML = `${statusText}<br>Guest is ${state.guest_ready ? 'Ready' : 'Not Ready'}.`;
+            }
+
+            addBotBtn.textContent = state.guest_is_bot ? 'Remove Computer' : 'Play vs Computer';
+            addBotBtn.disabled = state.player_count >= 2 && !state.guest_is_bot;
+            addBotBtn.dataset.remove = state.guest_is_bot ? 'true' : '';
 
             readyBtn.textContent = state.guest_ready ? 'Unready' : 'Ready';
+
+            const tc = state.time_control;
+            if (!tc) {
+                timeControlSelect.value = '';
+            } else if (tc.delay_type) {
+                timeControlSelect.value = `${tc.base_ms},${tc.increment_ms},${tc.delay_ms},${tc.delay_type}`;
+            } else {
+                timeControlSelect.value = `${tc.base_ms},${tc.increment_ms}`;
+            }
         } else {
             // For ranked games, hide lobby and show game container immediately
             lobbyContainer.classList.add('hidden');
@@ -115,21 +288,270 @@ document.addEventListener('DOMContentLoaded', function() {
             board.resize(); // Ensure the board redraws itself
         }
         currentFen = gameState.fen;
-        const gameStatusText = gameState.game_status.replace(/_/g, ' ');
-        statusEl.textContent = gameStatusText;
+        sideToMove = gameState.fen.split(' ')[1];
+        if (!gameState.result && premove && gameState.last_move &&
+            gameState.last_move.from === premove.from && gameState.last_move.to === premove.to) {
+            showPremove(null);
+        }
+        if (stateVersion && gameState.version > stateVersion + 1) {
+            console.warn(`Missed ${gameState.version - stateVersion - 1} game state update(s)`);
+        }
+        stateVersion = gameState.version;
+        highlightSquares(gameState);
+        renderMoveList(gameState.san || []);
+        const captured = gameState.captured || { white: [], black: [] };
+        capturedEl.textContent = [captured.white, captured.black]
+            .map(pieces => pieces.map(p => pieceSymbols[p] || p).join('') || '-')
+            .join(' / ');
+        gameInProgress = gameState.game_status === 'in_progress' && !gameState.result;
+        clock = gameState.clock || null;
+        clockReceivedAt = Date.now();
+        clocksEl.classList.toggle('hidden', !clock);
+        renderClocks();
+        showDrawOffer(gameState.draw_offer || '');
+        showTakebackRequest(gameState.takeback_request || '');
+        if (gameState.result) {
+            statusEl.textContent = `game over, ${gameState.result} (${gameState.termination.replace(/_/g, ' ')})`;
+        } else {
+            statusEl.textContent = gameState.game_status.replace(/_/g, ' ');
+        }
         fenEl.textContent = gameState.fen;
 
-        if (gameState.game_status !== 'in_progress') {
-            setTimeout(() => alert('Game Over: ' + gameStatusText), 300);
+        rematchEl.classList.toggle('hidden', !gameState.result);
+        if (gameState.result) {
+            showRematch(gameState.rematch_offer || '');
+        }
+    }
+
+    // Highlights the squares of the last move and the king in check
+    function highlightSquares(gameState) {
+        document.querySelectorAll('#myBoard .highlight-last-move, #myBoard .highlight-check').forEach(el => {
+            el.classList.remove('highlight-last-move', 'highlight-check');
+        });
+        const mark = (square, cls) => {
+            const el = document.querySelector(`#myBoard .square-${square}`);
+            if (el) el.classList.add(cls);
+        };
+        if (gameState.last_move) {
+            mark(gameState.last_move.from, 'highlight-last-move');
+            mark(gameState.last_move.to, 'highlight-last-move');
+        }
+        if (gameState.in_check) {
+            const turn = gameState.fen.split(' ')[1];
+            const king = Object.entries(Chessboard.fenToObj(gameState.fen))
+                .find(([, piece]) => piece === (turn === 'w' ? 'wK' : 'bK'));
+            if (king) mark(king[0], 'highlight-check');
         }
     }
 
+    // Marks the squares of our queued premove, or clears them
+    function showPremove(move) {
+        premove = move && move.from ? move : null;
+        document.querySelectorAll('#myBoard .highlight-premove').forEach(el => el.classList.remove('highlight-premove'));
+        if (!premove) return;
+        [premove.from, premove.to].forEach(square => {
+            const el = document.querySelector(`#myBoard .square-${square}`);
+            if (el) el.classList.add('highlight-premove');
+        });
+    }
+
+    function renderMoveList(sans) {
+        const rows = [];
+        for (let i = 0; i < sans.length; i += 2) {
+            rows.push(`${i / 2 + 1}. ${sans[i]} ${sans[i + 1] || ''}`);
+        }
+        moveListEl.textContent = rows.join('\n');
+        moveListEl.scrollTop = moveListEl.scrollHeight;
+    }
+
+    // Shows the rematch controls of a finished game and the pending offer
+    function showRematch(color) {
+        if (myColor === 'spectator') {
+            rematchTextEl.textContent = color ? `${color} offered a rematch.` : 'The game is over.';
+            offerRematchBtn.classList.add('hidden');
+            declineRematchBtn.classList.add('hidden');
+            return;
+        }
+        const offered = color && color !== myColor;
+        if (offered) {
+            rematchTextEl.textContent = 'Your opponent offers a rematch.';
+        } else if (color) {
+            rematchTextEl.textContent = 'Waiting for your opponent to accept the rematch...';
+        } else {
+            rematchTextEl.textContent = 'Play again with the colors swapped?';
+        }
+        offerRematchBtn.textContent = offered ? 'Accept' : 'Rematch';
+        offerRematchBtn.classList.toggle('hidden', color === myColor);
+        declineRematchBtn.classList.toggle('hidden', !offered);
+    }
+
+    function formatScore(score) {
+        if (score.mate !== undefined) {
+            return `#${score.mate}`;
+        }
+        const pawns = score.cp / 100;
+        return (pawns > 0 ? '+' : '') + pawns.toFixed(2);
+    }
+
+    function updateEngineInfo(info) {
+        // Only the first line of a multi-line analysis is shown
+        if (info.multi_pv > 1) return;
+        const source = info.source === 'bot' ? 'Computer' : 'Analysis';
+        const knps = Math.round(info.nps / 1000);
+        engineInfoEl.textContent = `${source} depth ${info.depth}: ${formatScore(info.score)} ` +
+            `${info.pv.join(' ')} (${knps} kN/s)`;
+    }
+
+    // Shows a pending draw offer. Only the opponent of the player who made it
+    // can answer.
+    function showDrawOffer(color) {
+        drawOfferEl.classList.toggle('hidden', !color);
+        if (!color) return;
+        const canAnswer = myColor !== 'spectator' && color !== myColor;
+        drawOfferTextEl.textContent = canAnswer ? 'Your opponent offers a draw.' : `${color} offered a draw.`;
+        acceptDrawBtn.classList.toggle('hidden', !canAnswer);
+        declineDrawBtn.classList.toggle('hidden', !canAnswer);
+    }
+
+    function showConnectionNotice(text, graceMs = 0, canClaim = false) {
+        connectionNoticeEl.classList.toggle('hidden', !text);
+        claimVictoryBtn.classList.toggle('hidden', !canClaim);
+        if (graceMs > 0) {
+            text += ` Waiting up to ${Math.round(graceMs / 1000)} seconds for them to return.`;
+        }
+        connectionNoticeTextEl.textContent = text;
+    }
+
+    // Shows a pending takeback request to be answered by the opponent
+    function showTakebackRequest(color) {
+        takebackRequestEl.classList.toggle('hidden', !color);
+        if (!color) return;
+        const canAnswer = myColor !== 'spectator' && color !== myColor;
+        takebackRequestTextEl.textContent = canAnswer ? 'Your opponent asks for a takeback.' : `${color} asked for a takeback.`;
+        acceptTakebackBtn.classList.toggle('hidden', !canAnswer);
+        declineTakebackBtn.classList.toggle('hidden', !canAnswer);
+    }
+
+    // Adds a chat message. The host, or the players of a room without a host,
+    // can mute or unmute its sender.
+    function appendChatMessage(chat) {
+        const line = document.createElement('div');
+        const time = new Date(chat.sent_at).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+        const author = document.createElement('strong');
+        author.textContent = `${chat.role} (${chat.user_id})`;
+        line.append(`[${time}] `, author, `: ${chat.text}`);
+
+        const canModerate = hostedRoom ? isHost : (myColor === 'white' || myColor === 'black');
+        if (canModerate && chat.role !== myColor) {
+            const muteLink = document.createElement('a');
+            muteLink.href = '#';
+            muteLink.className = 'ms-2 text-muted';
+            muteLink.textContent = mutedUsers.has(chat.user_id) ? 'unmute' : 'mute';
+            muteLink.addEventListener('click', (e) => {
+                e.preventDefault();
+                sendMessage(mutedUsers.has(chat.user_id) ? 'unmute' : 'mute', { user_id: chat.user_id });
+            });
+            line.append(muteLink);
+        }
+        chatMessagesEl.append(line);
+        chatMessagesEl.scrollTop = chatMessagesEl.scrollHeight;
+    }
+
+    function appendChatNotice(text) {
+        const line = document.createElement('div');
+        line.className = 'text-muted fst-italic';
+        line.textContent = text;
+        chatMessagesEl.append(line);
+        chatMessagesEl.scrollTop = chatMessagesEl.scrollHeight;
+    }
+
+    function formatClock(ms) {
+        const totalSeconds = Math.max(0, Math.ceil(ms / 1000));
+        const minutes = Math.floor(totalSeconds / 60);
+        const seconds = totalSeconds % 60;
+        return `${minutes}:${seconds.toString().padStart(2, '0')}`;
+    }
+
+    // The server only sends the clocks with each game state, so the running
+    // clock is counted down locally in between
+    function renderClocks() {
+        if (!clock) return;
+        const elapsed = Date.now() - clockReceivedAt;
+        let white = clock.white_ms;
+        let black = clock.black_ms;
+        if (clock.running === 'white') white -= elapsed;
+        if (clock.running === 'black') black -= elapsed;
+        whiteClockEl.textContent = formatClock(white);
+        blackClockEl.textContent = formatClock(black);
+    }
+    setInterval(renderClocks, 200);
+
     // --- Event Listeners ---
     document.querySelectorAll('input[name="color"]').forEach(radio => {
         radio.addEventListener('change', (e) => sendMessage('assign_color', { color: e.target.value.toLowerCase() }));
     });
     readyBtn.addEventListener('click', () => sendMessage('player_ready'));
     startGameBtn.addEventListener('click', () => sendMessage('start_game'));
+    timeControlSelect.addEventListener('change', () => {
+        const [base, increment, delay, delayType] = timeControlSelect.value.split(',');
+        sendMessage('set_time_control', {
+            base_ms: parseInt(base || '0', 10),
+            increment_ms: parseInt(increment || '0', 10),
+            delay_ms: parseInt(delay || '0', 10),
+            delay_type: delayType || '',
+        });
+    });
+    resignBtn.addEventListener('click', () => {
+        if (confirm('Resign the game?')) sendMessage('resign');
+    });
+    offerDrawBtn.addEventListener('click', () => sendMessage('offer_draw'));
+    abortBtn.addEventListener('click', () => sendMessage('abort'));
+    acceptDrawBtn.addEventListener('click', () => sendMessage('accept_draw'));
+    declineDrawBtn.addEventListener('click', () => sendMessage('decline_draw'));
+    claimVictoryBtn.addEventListener('click', () => sendMessage('claim_victory'));
+    takebackBtn.addEventListener('click', () => sendMessage('request_takeback'));
+    acceptTakebackBtn.addEventListener('click', () => sendMessage('accept_takeback'));
+    declineTakebackBtn.addEventListener('click', () => sendMessage('decline_takeback'));
+    stopSearchBtn.addEventListener('click', () => sendMessage('stop_search'));
+    analyzeBtn.addEventListener('click', () => sendMessage('analyze', { time_ms: 3000 }));
+    offerRematchBtn.addEventListener('click', () => sendMessage('offer_rematch'));
+    declineRematchBtn.addEventListener('click', () => sendMessage('decline_rematch'));
+    chatForm.addEventListener('submit', (e) => {
+        e.preventDefault();
+        const text = chatInput.value.trim();
+        if (!text) return;
+        sendMessage('chat', { text });
+        chatInput.value = '';
+    });
+    addBotBtn.addEventListener('click', () => {
+        if (addBotBtn.dataset.remove) {
+            sendMessage('remove_bot');
+        } else {
+            sendMessage('add_bot', {
+                level: parseInt(botLevelSelect.value, 10),
+                show_thinking: document.getElementById('botShowThinking').checked,
+            });
+        }
+    });
+
+    async function loadBotLevels() {
+        try {
+            const response = await fetch('/api/bot/levels', {
+                headers: { 'Authorization': `Bearer ${localStorage.getItem('jwtToken')}` }
+            });
+            const data = await response.json();
+            data.levels.forEach(level => {
+                const option = document.createElement('option');
+                option.value = level.id;
+                option.textContent = `${level.id}. ${level.name}`;
+                option.selected = level.id === data.default;
+                botLevelSelect.appendChild(option);
+            });
+        } catch (error) {
+            console.error('Failed to load bot levels:', error);
+        }
+    }
+    loadBotLevels();
 
     // --- Game Logic and Board UI ---
     function onDragStart(source, piece, position, orientation) {
@@ -140,6 +562,11 @@ document.addEventListener('DOMContentLoaded', function() {
     }
 
     function onDrop(source, target) {
+        // Dropping a piece off the board cancels the premove
+        if (target === 'offboard') {
+            if (premove) sendMessage('cancel_premove');
+            return 'snapback';
+        }
         currentFen = board.fen();
         const position = board.position();
         const piece = position[source];
@@ -155,6 +582,12 @@ document.addEventListener('DOMContentLoaded', function() {
                 return;
             }
         }
+        // Out of turn the move is queued as a premove and the board keeps
+        // showing the current position until it is played
+        if (sideToMove !== myColor[0]) {
+            sendMessage('premove', { from: source, to: target, promotion: promotionChoice.toLowerCase() });
+            return 'snapback';
+        }
         sendMessage('move', { from: source, to: target, promotion: promotionChoice.toLowerCase() });
     }
 
@@ -38,7 +38,7 @@ async function validate() {
         });
         if (response.ok) {
             const data = await response.json();
-            return { isValid: true, elo: data.elo };
+            return { isValid: true, elo: data.elo, userID: data.user_id };
         } else {
             return { isValid: false };
         }
@@ -53,6 +53,8 @@ document.addEventListener('DOMContentLoaded', async () => {
     const validationResult = await validate();
     if (validationResult.isValid) {
         eloValue.textContent = validationResult.elo;
+        lobbyUserID = validationResult.userID;
+        renderSeeks();
         eloDisplay.classList.remove('hidden');
     } else {
         eloDisplay.classList.add('hidden');
@@ -136,6 +138,8 @@ rankBtn.addEventListener("click", async function() {
 
     rankedWs.onopen = () => {
         console.log('Connected to ranked queue WebSocket');
+        // The server expects a hello with the protocol version first
+        rankedWs.send(JSON.stringify({ action: 'hello', payload: { protocol_version: 1 } }));
     };
 
     rankedWs.onmessage = (event) => {
@@ -180,4 +184,130 @@ leaveQueueBtn.addEventListener("click", function() {
         rankedWs.close(); // Close the WebSocket connection
         updateQueueStatus('Leaving ranked queue...');
     }
-});
\ No newline at end of file
+});
+// --- Lobby of open challenges ---
+
+let lobbyCard = document.getElementById("lobbyCard");
+let seekForm = document.getElementById("seekForm");
+let seekList = document.getElementById("seekList");
+let seekListEmpty = document.getElementById("seekListEmpty");
+let lobbyStatus = document.getElementById("lobbyStatus");
+
+let lobbyWs = null;
+let lobbyUserID = null; // Set once the token is validated, to tell our own seeks apart
+let lobbyRequests = 0;
+const lobbySeeks = new Map(); // seek ID -> seek
+
+function lobbySend(action, payload) {
+    if (lobbyWs && lobbyWs.readyState === WebSocket.OPEN) {
+        lobbyWs.send(JSON.stringify({ action, request_id: `lobby-${++lobbyRequests}`, payload }));
+    }
+}
+
+function describeTimeControl(tc) {
+    if (!tc) {
+        return 'Untimed';
+    }
+    return `${Math.round(tc.base_ms / 60000)}+${Math.round((tc.increment_ms || 0) / 1000)}`;
+}
+
+function renderSeeks() {
+    seekList.innerHTML = '';
+    const seeks = [...lobbySeeks.values()].sort((a, b) => a.created_at - b.created_at);
+    seekListEmpty.classList.toggle('hidden', seeks.length > 0);
+
+    for (const seek of seeks) {
+        const row = document.createElement('tr');
+        const range = seek.min_rating || seek.max_rating ? ` (${seek.min_rating || 0}-${seek.max_rating || '∞'})` : '';
+        [
+            `Player ${seek.user_id} (${seek.elo})`,
+            describeTimeControl(seek.time_control),
+            (seek.rated ? 'Rated' : 'Casual') + range,
+            seek.color,
+        ].forEach(text => {
+            const cell = document.createElement('td');
+            cell.textContent = text;
+            row.appendChild(cell);
+        });
+
+        const actionCell = document.createElement('td');
+        const button = document.createElement('button');
+        button.className = 'btn btn-sm';
+        if (seek.user_id === lobbyUserID) {
+            button.classList.add('btn-outline-danger');
+            button.textContent = 'Cancel';
+            button.addEventListener('click', () => lobbySend('cancel_seek', { seek_id: seek.id }));
+        } else {
+            button.classList.add('btn-primary');
+            button.textContent = 'Accept';
+            button.addEventListener('click', () => lobbySend('accept_seek', { seek_id: seek.id }));
+        }
+        actionCell.appendChild(button);
+        row.appendChild(actionCell);
+        seekList.appendChild(row);
+    }
+}
+
+function connectLobby() {
+    const token = localStorage.getItem('jwtToken');
+    if (!token) {
+        return;
+    }
+    const protocol = window.location.protocol === 'https:' ? 'wss://' : 'ws://';
+    lobbyWs = new WebSocket(`${protocol}${window.location.host}/ws/game/lobby?token=${token}`);
+
+    lobbyWs.onopen = () => {
+        lobbyWs.send(JSON.stringify({ action: 'hello', payload: { protocol_version: 1 } }));
+        lobbyCard.classList.remove('hidden');
+    };
+
+    lobbyWs.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        switch (msg.action) {
+            case 'lobby_seeks':
+                lobbySeeks.clear();
+                msg.payload.seeks.forEach(seek => lobbySeeks.set(seek.id, seek));
+                renderSeeks();
+                break;
+            case 'seek_added':
+                lobbySeeks.set(msg.payload.id, msg.payload);
+                renderSeeks();
+                break;
+            case 'seek_removed':
+                lobbySeeks.delete(msg.payload.seek_id);
+                renderSeeks();
+                break;
+            case 'seek_accepted':
+                window.location.href = `/game?room=${msg.payload.room_id}`;
+                break;
+            case 'error':
+                lobbyStatus.textContent = msg.payload.message;
+                break;
+        }
+    };
+
+    lobbyWs.onclose = () => {
+        lobbyCard.classList.add('hidden');
+    };
+}
+
+seekForm.addEventListener('submit', (event) => {
+    event.preventDefault();
+    lobbyStatus.textContent = '';
+
+    const minutes = parseFloat(document.getElementById('seekMinutes').value) || 0;
+    const increment = parseFloat(document.getElementById('seekIncrement').value) || 0;
+    const payload = {
+        variant: 'standard',
+        rated: document.getElementById('seekRated').checked,
+        color: document.getElementById('seekColor').value,
+        min_rating: parseInt(document.getElementById('seekMinRating').value, 10) || 0,
+        max_rating: parseInt(document.getElementById('seekMaxRating').value, 10) || 0,
+    };
+    if (minutes > 0) {
+        payload.time_control = { base_ms: Math.round(minutes * 60000), increment_ms: Math.round(increment * 1000), delay_ms: 0 };
+    }
+    lobbySend('create_seek', payload);
+});
+
+document.addEventListener('DOMContentLoaded', connectLobby);
@@ -105,7 +105,7 @@ func ValidateHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Token validated", "elo": u.ELO})
+	c.JSON(http.StatusOK, gin.H{"message": "Token validated", "elo": u.ELO, "user_id": u.ID})
 }
 
 func AuthMiddleware() gin.HandlerFunc {
@@ -0,0 +1,284 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits for analysis requests
+const (
+	defaultAnalysisDepth = 4
+	maxAnalysisDepth     = 8
+	maxAnalysisTime      = 30 * time.Second
+	maxMultiPV           = 5
+)
+
+// analysisBot serves analysis requests. Its depth is given per request.
+var analysisBot = NewChessBot(maxAnalysisDepth)
+
+// analysisSlots caps the analyses running at once at half the cores. Each
+// analysis searches on a single thread, so the other half stays free for the
+// bots of live games.
+var analysisSlots = make(chan struct{}, max(1, runtime.NumCPU()/2))
+
+// ErrAnalysisBusy is returned when every analysis slot is taken
+var ErrAnalysisBusy = errors.New("Too many analyses are running, please try again later")
+
+// StartAnalysis takes an analysis slot without waiting for one. The returned
+// function gives the slot back once the analysis is over.
+func StartAnalysis() (func(), error) {
+	select {
+	case analysisSlots <- struct{}{}:
+		return func() { <-analysisSlots }, nil
+	default:
+		return nil, ErrAnalysisBusy
+	}
+}
+
+// Score is an evaluation as shown to users: either centipawns or a forced
+// mate in a number of moves, always from White's point of view
+type Score struct {
+	CP   *int `json:"cp,omitempty"`
+	Mate *int `json:"mate,omitempty"` // Negative when Black mates
+}
+
+// NewScore converts a search score taken from the perspective of the side to
+// move into a Score
+func NewScore(score int, turn engine.Color) Score {
+	if turn == engine.Black {
+		score = -score
+	}
+	if mate, ok := mateIn(score); ok {
+		return Score{Mate: &mate}
+	}
+	return Score{CP: &score}
+}
+
+// Centipawns returns the score in centipawns, mapping mates to large values
+func (s Score) Centipawns() int {
+	if s.Mate != nil {
+		if *s.Mate > 0 {
+			return mateScore - *s.Mate
+		}
+		return -mateScore - *s.Mate
+	}
+	if s.CP != nil {
+		return *s.CP
+	}
+	return 0
+}
+
+// mateIn returns the number of moves to mate for mate scores
+func mateIn(score int) (int, bool) {
+	switch {
+	case score > mateScore-maxPly:
+		return (mateScore - score + 1) / 2, true
+	case score < -mateScore+maxPly:
+		return -(mateScore + score + 1) / 2, true
+	}
+	return 0, false
+}
+
+// AnalysisOptions limits an analysis
+type AnalysisOptions struct {
+	Depth    int
+	MoveTime time.Duration // 0 means no time limit
+	MultiPV  int
+
+	// Every line is reported after each completed depth
+	SearchControl
+}
+
+// AnalysisLine is one of the best lines found by an analysis
+type AnalysisLine struct {
+	Score Score         `json:"score"`
+	Moves []engine.Move `json:"-"`
+	PV    []string      `json:"pv"`    // SAN
+	PVUCI []string      `json:"pvUci"` // Coordinate notation
+}
+
+// AnalysisResult is the outcome of an analysis
+type AnalysisResult struct {
+	FEN        string         `json:"fen"`
+	GameStatus string         `json:"gameStatus"`
+	Depth      int            `json:"depth"`
+	Nodes      int64          `json:"nodes"`
+	TimeMs     int64          `json:"timeMs"`
+	NPS        int64          `json:"nps"`
+	Lines      []AnalysisLine `json:"lines"`
+}
+
+// Analyze searches the position and returns the best MultiPV lines of the
+// deepest iteration that completed for every line. Each further line is found
+// by searching the root again with the moves of the earlier lines excluded.
+func (bot *ChessBot) Analyze(pos *engine.Position, opts AnalysisOptions) AnalysisResult {
+	s := newSearcher(bot, pos, nil)
+	s.timeLimit = opts.MoveTime
+	defer s.control(opts.SearchControl)()
+
+	result := AnalysisResult{FEN: pos.String(), GameStatus: pos.GetGameStatus().String()}
+	multiPV := max(1, min(opts.MultiPV, len(pos.GenerateLegalMoves())))
+
+	type rootLine struct {
+		score int
+		pv    []engine.Move
+	}
+	var lines []rootLine
+
+	if result.GameStatus == engine.InProgress.String() {
+		for depth := 1; depth <= opts.Depth; depth++ {
+			var current []rootLine
+			s.excluded = nil
+			for k := 0; k < multiPV; k++ {
+				s.prevPV = nil
+				if k < len(lines) {
+					s.prevPV = lines[k].pv
+				}
+				s.followPV = true
+
+				score := s.negamax(pos, depth, 0, -infinity, infinity)
+				if s.stop.Load() {
+					break
+				}
+				pv := s.extendPV(pos, s.principalVariation(), depth)
+				if len(pv) == 0 {
+					break
+				}
+				current = append(current, rootLine{score: score, pv: pv})
+				s.excluded = append(s.excluded, pv[0])
+			}
+			if s.stop.Load() {
+				break
+			}
+
+			sort.SliceStable(current, func(i, j int) bool { return current[i].score > current[j].score })
+			lines = current
+			result.Depth = depth
+			for k, line := range lines {
+				s.report(pos, depth, k+1, line.score, line.pv)
+			}
+
+			// The first iteration always completes so there are lines to show
+			if depth == 1 {
+				s.armStop()
+			}
+		}
+	}
+
+	for _, line := range lines {
+		uci := make([]string, len(line.pv))
+		for i, move := range line.pv {
+			uci[i] = move.String()
+		}
+		result.Lines = append(result.Lines, AnalysisLine{
+			Score: NewScore(line.score, pos.Turn),
+			Moves: line.pv,
+			PV:    engine.MovesToSAN(pos, line.pv),
+			PVUCI: uci,
+		})
+	}
+
+	elapsed := time.Since(s.start)
+	result.Nodes = s.stats.Nodes
+	result.TimeMs = elapsed.Milliseconds()
+	if elapsed > 0 {
+		result.NPS = int64(float64(s.stats.Nodes) / elapsed.Seconds())
+	}
+	return result
+}
+
+// AnalyzeRequest is the body of an analysis request. The position is the FEN,
+// or the starting position if empty, followed by the optional moves in
+// coordinate notation or SAN.
+type AnalyzeRequest struct {
+	FEN     string   `json:"fen"`
+	Moves   []string `json:"moves"`
+	Depth   int      `json:"depth"`
+	TimeMs  int      `json:"timeMs"`
+	MultiPV int      `json:"multiPv"`
+}
+
+// AnalyzeHandler analyzes a position and returns its best lines
+func AnalyzeHandler(c *gin.Context) {
+	var req AnalyzeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	pos, err := positionFromRequest(req.FEN, req.Moves)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts, err := NewAnalysisOptions(req.Depth, time.Duration(req.TimeMs)*time.Millisecond, req.MultiPV)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	done, err := StartAnalysis()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer done()
+
+	c.JSON(http.StatusOK, AnalyzePosition(pos, opts))
+}
+
+// NewAnalysisOptions applies the defaults and limits of analysis requests. A
+// zero depth searches the default depth, or as deep as allowed when a move
+// time is given. Every analysis is capped at maxAnalysisTime, even when only
+// a depth is given.
+func NewAnalysisOptions(depth int, moveTime time.Duration, multiPV int) (AnalysisOptions, error) {
+	opts := AnalysisOptions{Depth: depth, MoveTime: moveTime, MultiPV: multiPV}
+	if opts.Depth <= 0 {
+		opts.Depth = defaultAnalysisDepth
+		if opts.MoveTime > 0 {
+			opts.Depth = maxAnalysisDepth
+		}
+	}
+	if opts.Depth > maxAnalysisDepth || opts.MoveTime < 0 || opts.MoveTime > maxAnalysisTime || opts.MultiPV < 0 || opts.MultiPV > maxMultiPV {
+		return opts, fmt.Errorf("Limits exceeded: depth up to %d, time up to %dms, multiPv up to %d",
+			maxAnalysisDepth, maxAnalysisTime.Milliseconds(), maxMultiPV)
+	}
+	if opts.MoveTime == 0 {
+		opts.MoveTime = maxAnalysisTime
+	}
+	return opts, nil
+}
+
+// AnalyzePosition analyzes a position with the bot that serves analysis
+// requests
+func AnalyzePosition(pos *engine.Position, opts AnalysisOptions) AnalysisResult {
+	return analysisBot.Analyze(pos, opts)
+}
+
+// positionFromRequest builds a position from a FEN (the starting position if
+// empty) and a list of moves played from it
+func positionFromRequest(fen string, moves []string) (*engine.Position, error) {
+	pos := engine.NewGame()
+	if fen != "" {
+		var err error
+		if pos, err = engine.ParseFEN(fen); err != nil {
+			return nil, fmt.Errorf("Invalid FEN: %v", err)
+		}
+	}
+	for i, moveStr := range moves {
+		move, err := engine.ParseMoveAny(pos, moveStr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid move %d: %v", i+1, err)
+		}
+		pos = engine.ApplyMove(pos, move)
+	}
+	return pos, nil
+}
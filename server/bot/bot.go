@@ -1,128 +1,120 @@
 package bot
 
 import (
-	"fmt"
-	"log"
-	"math"
-	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/TLeTu/Chess-Media/server/engine"
-	"github.com/gin-gonic/gin"
 )
 
-type MoveRequest struct {
-	CurrentFEN     string `json:"currentFen"`
-	PlayerMove     string `json:"playerMove"`
-	PromotionPiece string `json:"promotionPiece"`
-}
-
-type MoveResponse struct {
-	NewFEN     string `json:"newFen"`
-	GameStatus string `json:"gameStatus"`
-}
-
 // ChessBot represents an intelligent chess bot
 type ChessBot struct {
-	maxDepth int
+	params        *EvalParams
+	maxDepth      int
+	moveTime      time.Duration
+	evalNoise     int
+	blunderChance float64
+	moveOrdering  bool
+	threads       int
+
+	ttOnce sync.Once
+	tt     *transpositionTable
 }
 
 // NewChessBot creates a new chess bot with specified search depth
 func NewChessBot(depth int) *ChessBot {
-	return &ChessBot{maxDepth: depth}
+	return &ChessBot{params: DefaultEvalParams(), maxDepth: depth, moveOrdering: true, threads: 1}
 }
 
-// Piece values for evaluation
-var pieceValues = map[engine.PieceType]int{
-	engine.Pawn:   100,
-	engine.Knight: 320,
-	engine.Bishop: 330,
-	engine.Rook:   500,
-	engine.Queen:  900,
-	engine.King:   20000,
+// SetThreads sets the number of threads used by a search. Helper threads
+// beyond the first are only used while the global worker budget allows.
+func (bot *ChessBot) SetThreads(threads int) {
+	bot.threads = max(1, threads)
 }
 
-// Piece-square tables for positional evaluation
-var pawnTable = [64]int{
-	0, 0, 0, 0, 0, 0, 0, 0,
-	50, 50, 50, 50, 50, 50, 50, 50,
-	10, 10, 20, 30, 30, 20, 10, 10,
-	5, 5, 10, 25, 25, 10, 5, 5,
-	0, 0, 0, 20, 20, 0, 0, 0,
-	5, -5, -10, 0, 0, -10, -5, 5,
-	5, 10, 10, -20, -20, 10, 10, 5,
-	0, 0, 0, 0, 0, 0, 0, 0,
+// SetDepth sets the maximum search depth
+func (bot *ChessBot) SetDepth(depth int) {
+	bot.maxDepth = max(1, min(depth, maxPly-1))
 }
 
-var knightTable = [64]int{
-	-50, -40, -30, -30, -30, -30, -40, -50,
-	-40, -20, 0, 0, 0, 0, -20, -40,
-	-30, 0, 10, 15, 15, 10, 0, -30,
-	-30, 5, 15, 20, 20, 15, 5, -30,
-	-30, 0, 15, 20, 20, 15, 0, -30,
-	-30, 5, 10, 15, 15, 10, 5, -30,
-	-40, -20, 0, 5, 5, 0, -20, -40,
-	-50, -40, -30, -30, -30, -30, -40, -50,
+// SetMoveTime sets the time a search may take. Zero means no time limit.
+func (bot *ChessBot) SetMoveTime(moveTime time.Duration) {
+	bot.moveTime = moveTime
 }
 
-var bishopTable = [64]int{
-	-20, -10, -10, -10, -10, -10, -10, -20,
-	-10, 0, 0, 0, 0, 0, 0, -10,
-	-10, 0, 5, 10, 10, 5, 0, -10,
-	-10, 5, 5, 10, 10, 5, 5, -10,
-	-10, 0, 10, 10, 10, 10, 0, -10,
-	-10, 10, 10, 10, 10, 10, 10, -10,
-	-10, 5, 0, 0, 0, 0, 5, -10,
-	-20, -10, -10, -10, -10, -10, -10, -20,
+// transpositionTable returns the bot's table, allocating it on first use
+func (bot *ChessBot) transpositionTable() *transpositionTable {
+	bot.ttOnce.Do(func() {
+		bot.tt = newTranspositionTable(defaultTTEntries)
+	})
+	return bot.tt
 }
 
-var rookTable = [64]int{
-	0, 0, 0, 0, 0, 0, 0, 0,
-	5, 10, 10, 10, 10, 10, 10, 5,
-	-5, 0, 0, 0, 0, 0, 0, -5,
-	-5, 0, 0, 0, 0, 0, 0, -5,
-	-5, 0, 0, 0, 0, 0, 0, -5,
-	-5, 0, 0, 0, 0, 0, 0, -5,
-	-5, 0, 0, 0, 0, 0, 0, -5,
-	0, 0, 0, 5, 5, 0, 0, 0,
+// SetEvalParams replaces the evaluation weights used by the bot
+func (bot *ChessBot) SetEvalParams(params *EvalParams) {
+	bot.params = params
 }
 
-var queenTable = [64]int{
-	-20, -10, -10, -5, -5, -10, -10, -20,
-	-10, 0, 0, 0, 0, 0, 0, -10,
-	-10, 0, 5, 5, 5, 5, 0, -10,
-	-5, 0, 5, 5, 5, 5, 0, -5,
-	0, 0, 5, 5, 5, 5, 0, -5,
-	-10, 5, 5, 5, 5, 5, 0, -10,
-	-10, 0, 5, 0, 0, 0, 0, -10,
-	-20, -10, -10, -5, -5, -10, -10, -20,
+// SetMoveOrdering enables or disables move ordering. Searching with ordering
+// disabled visits moves in generation order, which is mainly useful to compare
+// node counts against the ordered search.
+func (bot *ChessBot) SetMoveOrdering(enabled bool) {
+	bot.moveOrdering = enabled
+}
+
+// Game phase weights of the pieces. The phase is maxPhase with all pieces on
+// the board and 0 when only kings and pawns remain.
+const (
+	knightPhase = 1
+	bishopPhase = 1
+	rookPhase   = 2
+	queenPhase  = 4
+	maxPhase    = 4*knightPhase + 4*bishopPhase + 4*rookPhase + 2*queenPhase
+)
+
+// evaluatePosition evaluates the current position from the perspective of the given color
+func (bot *ChessBot) evaluatePosition(pos *engine.Position, color engine.Color) int {
+	score := taper(bot.evaluateTerms(pos, nil), gamePhase(pos))
+	score, _ = bot.evaluateEndgame(pos, score)
+	if color == engine.Black {
+		return -score
+	}
+	return score
 }
 
-var kingMiddleGameTable = [64]int{
-	-30, -40, -40, -50, -50, -40, -40, -30,
-	-30, -40, -40, -50, -50, -40, -40, -30,
-	-30, -40, -40, -50, -50, -40, -40, -30,
-	-30, -40, -40, -50, -50, -40, -40, -30,
-	-20, -30, -30, -40, -40, -30, -30, -20,
-	-10, -20, -20, -20, -20, -20, -20, -10,
-	20, 20, 0, 0, 0, 0, 20, 20,
-	20, 30, 10, 0, 0, 10, 30, 20,
+// taper blends a middlegame and endgame score by the game phase
+func taper(score Tapered, phase int) int {
+	return (score.MG*phase + score.EG*(maxPhase-phase)) / maxPhase
 }
 
-var kingEndGameTable = [64]int{
-	-50, -40, -30, -20, -20, -30, -40, -50,
-	-30, -20, -10, 0, 0, -10, -20, -30,
-	-30, -10, 20, 30, 30, 20, -10, -30,
-	-30, -10, 30, 40, 40, 30, -10, -30,
-	-30, -10, 30, 40, 40, 30, -10, -30,
-	-30, -10, 20, 30, 30, 20, -10, -30,
-	-30, -30, 0, 0, 0, 0, -30, -30,
-	-50, -30, -30, -30, -30, -30, -30, -50,
+// gamePhase measures the material left on the board, from maxPhase at the
+// start of the game down to 0
+func gamePhase(pos *engine.Position) int {
+	phase := 0
+	for sq := engine.A1; sq <= engine.H8; sq++ {
+		switch pos.Board[sq].Type() {
+		case engine.Knight:
+			phase += knightPhase
+		case engine.Bishop:
+			phase += bishopPhase
+		case engine.Rook:
+			phase += rookPhase
+		case engine.Queen:
+			phase += queenPhase
+		}
+	}
+	// Promotions can add material beyond the starting position
+	return min(phase, maxPhase)
 }
 
-// evaluatePosition evaluates the current position from the perspective of the given color
-func (bot *ChessBot) evaluatePosition(pos *engine.Position, color engine.Color) int {
-	score := 0
+// evaluateTerms adds up the middlegame and endgame scores of the position from
+// White's point of view. Every term is also recorded in trace, if given.
+func (bot *ChessBot) evaluateTerms(pos *engine.Position, trace *EvalTrace) Tapered {
+	var sides [2]Tapered
+	add := func(term string, color engine.Color, value Tapered) {
+		sides[colorIndex(color)] = sides[colorIndex(color)].Add(value)
+		trace.add(term, color, value)
+	}
 
 	// Material and positional evaluation
 	for sq := engine.A1; sq <= engine.H8; sq++ {
@@ -130,87 +122,43 @@ func (bot *ChessBot) evaluatePosition(pos *engine.Position, color engine.Color)
 		if piece == engine.Empty {
 			continue
 		}
-
-		pieceValue := pieceValues[piece.Type()]
-		positionValue := bot.getPositionValue(piece, sq, pos)
-
-		if piece.Color() == color {
-			score += pieceValue + positionValue
-		} else {
-			score -= pieceValue + positionValue
+		// Both sides always have a king, so its value would only cancel out
+		if piece.Type() != engine.King {
+			add(TermMaterial, piece.Color(), bot.params.PieceValues.Value(piece.Type()))
 		}
+		add(pieceSquareTerm(piece.Type()), piece.Color(), bot.getPositionValue(piece, sq))
 	}
 
 	// Mobility bonus
-	legalMoves := pos.GenerateLegalMoves()
-	if pos.Turn == color {
-		score += len(legalMoves) * 10
-	} else {
-		score -= len(legalMoves) * 10
-	}
-
-	// King safety
-	score += bot.evaluateKingSafety(pos, color)
-	score -= bot.evaluateKingSafety(pos, oppositeColor(color))
+	add(TermMobility, pos.Turn, bot.params.MobilityWeight.Scale(len(pos.GenerateLegalMoves())))
 
-	// Pawn structure
-	score += bot.evaluatePawnStructure(pos, color)
-	score -= bot.evaluatePawnStructure(pos, oppositeColor(color))
+	for _, color := range []engine.Color{engine.White, engine.Black} {
+		add(TermKingSafety, color, bot.evaluateKingSafety(pos, color))
+		add(TermPawnStructure, color, bot.evaluatePawnStructure(pos, color))
+		add(TermPassedPawns, color, bot.params.PassedPawnBonus.Scale(bot.countPassedPawns(pos, color)))
+	}
 
-	return score
+	return sides[0].Add(sides[1].Scale(-1))
 }
 
 // getPositionValue returns the positional value of a piece on a given square
-func (bot *ChessBot) getPositionValue(piece engine.Piece, sq engine.Square, pos *engine.Position) int {
+func (bot *ChessBot) getPositionValue(piece engine.Piece, sq engine.Square) Tapered {
+	// The tables start at A8, so White's squares are mirrored vertically and
+	// Black's are already seen from their own side
 	index := int(sq)
-
-	// Flip the table for black pieces
-	if piece.Color() == engine.Black {
-		index = 63 - index
+	if piece.Color() == engine.White {
+		index ^= 56
 	}
 
-	switch piece.Type() {
-	case engine.Pawn:
-		return pawnTable[index]
-	case engine.Knight:
-		return knightTable[index]
-	case engine.Bishop:
-		return bishopTable[index]
-	case engine.Rook:
-		return rookTable[index]
-	case engine.Queen:
-		return queenTable[index]
-	case engine.King:
-		if bot.isEndGame(pos) {
-			return kingEndGameTable[index]
-		}
-		return kingMiddleGameTable[index]
+	return Tapered{
+		MG: bot.params.MiddleGameTables.Table(piece.Type())[index],
+		EG: bot.params.EndGameTables.Table(piece.Type())[index],
 	}
-	return 0
-}
-
-// isEndGame determines if the position is in the endgame
-func (bot *ChessBot) isEndGame(pos *engine.Position) bool {
-	queens := 0
-	minorPieces := 0
-
-	for sq := engine.A1; sq <= engine.H8; sq++ {
-		piece := pos.Board[sq]
-		switch piece.Type() {
-		case engine.Queen:
-			queens++
-		case engine.Knight, engine.Bishop:
-			minorPieces++
-		}
-	}
-
-	// Endgame if no queens or very few pieces
-	return queens == 0 || (queens == 2 && minorPieces <= 1)
 }
 
 // evaluateKingSafety evaluates king safety
-func (bot *ChessBot) evaluateKingSafety(pos *engine.Position, color engine.Color) int {
-	safety := 0
+func (bot *ChessBot) evaluateKingSafety(pos *engine.Position, color engine.Color) Tapered {
+	var safety Tapered
 
 	// Find the king
 	var kingSquare engine.Square = engine.NoSquare
@@ -223,25 +171,23 @@ func (bot *ChessBot) evaluateKingSafety(pos *engine.Position, color engine.Color
 	}
 
 	if kingSquare == engine.NoSquare {
-		return -10000 // King not found (shouldn't happen)
+		return safety // King not found (shouldn't happen)
 	}
 
 	// Check for pawn shield
 	if color == engine.White && kingSquare >= engine.A1 && kingSquare <= engine.H2 {
 		// White king on back ranks
-		safety += bot.countPawnShield(pos, kingSquare, color) * 10
+		safety = safety.Add(bot.params.PawnShieldBonus.Scale(bot.countPawnShield(pos, kingSquare, color)))
 	} else if color == engine.Black && kingSquare >= engine.A7 && kingSquare <= engine.H8 {
 		// Black king on back ranks
-		safety += bot.countPawnShield(pos, kingSquare, color) * 10
+		safety = safety.Add(bot.params.PawnShieldBonus.Scale(bot.countPawnShield(pos, kingSquare, color)))
 	}
 
-	// Penalty for king in center during middle game
-	if !bot.isEndGame(pos) {
-		kingFile := int(kingSquare % 8)
-		kingRank := int(kingSquare / 8)
-		if kingFile >= 2 && kingFile <= 5 && kingRank >= 2 && kingRank <= 5 {
-			safety -= 20
-		}
+	// Penalty for king in center, which mostly matters in the middlegame
+	kingFile := int(kingSquare % 8)
+	kingRank := int(kingSquare / 8)
+	if kingFile >= 2 && kingFile <= 5 && kingRank >= 2 && kingRank <= 5 {
+		safety = safety.Add(bot.params.KingCenterPenalty.Scale(-1))
 	}
 
 	return safety
@@ -276,9 +222,10 @@ func (bot *ChessBot) countPawnShield(pos *engine.Position, kingSquare engine.Squ
 	return count
 }
 
-// evaluatePawnStructure evaluates pawn structure
-func (bot *ChessBot) evaluatePawnStructure(pos *engine.Position, color engine.Color) int {
-	score := 0
+// evaluatePawnStructure evaluates pawn structure. Passed pawns are scored
+// separately.
+func (bot *ChessBot) evaluatePawnStructure(pos *engine.Position, color engine.Color) Tapered {
+	var score Tapered
 
 	// Count pawns per file
 	fileCounts := make([]int, 8)
@@ -293,13 +240,10 @@ func (bot *ChessBot) evaluatePawnStructure(pos *engine.Position, color engine.Co
 	// Penalty for doubled pawns
 	for _, count := range fileCounts {
 		if count > 1 {
-			score -= (count - 1) * 10
+			score = score.Add(bot.params.DoubledPawnPenalty.Scale(1 - count))
 		}
 	}
 
-	// Bonus for passed pawns
-	score += bot.countPassedPawns(pos, color) * 20
-
 	return score
 }
 
@@ -346,66 +290,6 @@ func (bot *ChessBot) isPassedPawn(pos *engine.Position, pawnSquare engine.Square
 	return true
 }
 
-// minimax implements the minimax algorithm with alpha-beta pruning
-func (bot *ChessBot) minimax(pos *engine.Position, depth int, alpha, beta int, maximizingPlayer bool, botColor engine.Color) int {
-	if depth == 0 || pos.GetGameStatus() != engine.InProgress {
-		return bot.evaluatePosition(pos, botColor)
-	}
-
-	moves := pos.GenerateLegalMoves()
-
-	if maximizingPlayer {
-		maxEval := math.MinInt32
-		for _, move := range moves {
-			newPos := engine.ApplyMove(pos, move)
-			eval := bot.minimax(newPos, depth-1, alpha, beta, false, botColor)
-			maxEval = max(maxEval, eval)
-			alpha = max(alpha, eval)
-			if beta <= alpha {
-				break // Alpha-beta pruning
-			}
-		}
-		return maxEval
-	} else {
-		minEval := math.MaxInt32
-		for _, move := range moves {
-			newPos := engine.ApplyMove(pos, move)
-			eval := bot.minimax(newPos, depth-1, alpha, beta, true, botColor)
-			minEval = min(minEval, eval)
-			beta = min(beta, eval)
-			if beta <= alpha {
-				break // Alpha-beta pruning
-			}
-		}
-		return minEval
-	}
-}
-
-// getBestMove finds the best move using minimax algorithm
-func (bot *ChessBot) getBestMove(pos *engine.Position) engine.Move {
-	moves := pos.GenerateLegalMoves()
-	if len(moves) == 0 {
-		return engine.Move{} // No legal moves
-	}
-
-	bestMove := moves[0]
-	bestValue := math.MinInt32
-	botColor := pos.Turn
-
-	for _, move := range moves {
-		newPos := engine.ApplyMove(pos, move)
-		value := bot.minimax(newPos, bot.maxDepth-1, math.MinInt32, math.MaxInt32, false, botColor)
-
-		if value > bestValue {
-			bestValue = value
-			bestMove = move
-		}
-	}
-
-	log.Printf("Bot chose move %s with evaluation %d", bestMove.String(), bestValue)
-	return bestMove
-}
-
 // Helper functions
 func max(a, b int) int {
 	if a > b {
@@ -427,96 +311,3 @@ func oppositeColor(c engine.Color) engine.Color {
 	}
 	return engine.White
 }
-
-// Global bot instance
-var smartBot = NewChessBot(4) // Search depth of 4 moves
-
-func BotMoveHandler(c *gin.Context) {
-	_, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-	var req MoveRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
-
-	log.Printf("Received move request: %+v\n", req)
-
-	// Parse the current FEN into a Position object
-	currentPos, err := engine.ParseFEN(req.CurrentFEN)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid FEN: %v", err)})
-		return
-	}
-
-	// Generate all legal moves for the current position
-	legalMoves := currentPos.GenerateLegalMoves()
-
-	// Find the player's move among the legal moves
-	playerMove := engine.Move{}
-	foundPlayerMove := false
-	for _, move := range legalMoves {
-		// For promotion moves, check if the promotion piece matches
-		if move.Promotion != engine.NoPieceType && req.PromotionPiece != "" {
-			promotedPieceType := engine.PieceType(0)
-			switch strings.ToLower(req.PromotionPiece) {
-			case "q":
-				promotedPieceType = engine.Queen
-			case "r":
-				promotedPieceType = engine.Rook
-			case "b":
-				promotedPieceType = engine.Bishop
-			case "n":
-				promotedPieceType = engine.Knight
-			}
-			if move.From.String()+move.To.String() == req.PlayerMove && move.Promotion == promotedPieceType {
-				playerMove = move
-				foundPlayerMove = true
-				break
-			}
-		} else if move.From.String()+move.To.String() == req.PlayerMove && move.Promotion == engine.NoPieceType {
-			playerMove = move
-			foundPlayerMove = true
-			break
-		}
-	}
-
-	if !foundPlayerMove {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid player move"})
-		return
-	}
-
-	// Apply the player's move
-	newPos := engine.ApplyMove(currentPos, playerMove)
-
-	// Check game status after player's move
-	status := newPos.GetGameStatus()
-	gameStatus := status.String()
-
-	if gameStatus != "in_progress" {
-		c.JSON(http.StatusOK, MoveResponse{
-			NewFEN:     newPos.String(),
-			GameStatus: gameStatus,
-		})
-		return
-	}
-
-	// Bot's turn: Use the smart bot to find the best move
-	botMove := smartBot.getBestMove(newPos)
-	if botMove.From != botMove.To { // Valid move found
-		newPos = engine.ApplyMove(newPos, botMove)
-
-		// Check game status after bot's move
-		status := newPos.GetGameStatus()
-		gameStatus = status.String()
-	}
-
-	// Respond with the new FEN and game status
-	c.JSON(http.StatusOK, MoveResponse{
-		NewFEN:     newPos.String(),
-		GameStatus: gameStatus,
-	})
-}
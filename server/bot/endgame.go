@@ -0,0 +1,266 @@
+package bot
+
+import (
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Endgame rules applied on top of the evaluation, as named in traces
+const (
+	EndgameCannotWin       = "cannot_win"
+	EndgameDrawishMaterial = "drawish_material"
+	EndgameOppositeBishops = "opposite_bishops"
+	EndgameKBNK            = "kbnk"
+	EndgameKPK             = "kpk"
+	EndgameMopUp           = "mop_up"
+)
+
+// Divisors scores are scaled down by in endgames that are hard to win
+const (
+	drawScale                = 16 // Drawn endgames, kept above zero so the search still prefers the better side
+	drawishScale             = 8  // Advantages that are not enough to win
+	oppositeBishopsScale     = 2  // Opposite bishops with more than one extra pawn
+	oppositeBishopsScaleNear = 4  // Opposite bishops with at most one extra pawn
+)
+
+// Weights of the bonuses that guide the strong side towards mate
+const (
+	mopUpCenter = 20  // Per step of the weak king away from the center
+	mopUpClose  = 10  // Per step the kings are closer than opposite corners
+	mopUpArea   = 5   // Per square the weak king cannot reach
+	kbnkCorner  = 100 // Per step of the weak king towards a corner of the bishop's color
+	kbnkClose   = 20  // Per step the kings are closer than opposite corners
+)
+
+// materialSignature counts the material of both sides, indexed by
+// colorIndex and piece type
+type materialSignature struct {
+	counts  [2][7]int
+	kings   [2]engine.Square
+	bishops [2]engine.Square // The last bishop found of each side
+	pawns   [2]engine.Square // The last pawn found of each side
+}
+
+func newMaterialSignature(pos *engine.Position) materialSignature {
+	var sig materialSignature
+	for sq := engine.A1; sq <= engine.H8; sq++ {
+		piece := pos.Board[sq]
+		if piece == engine.Empty {
+			continue
+		}
+		side := colorIndex(piece.Color())
+		sig.counts[side][piece.Type()]++
+		switch piece.Type() {
+		case engine.King:
+			sig.kings[side] = sq
+		case engine.Bishop:
+			sig.bishops[side] = sq
+		case engine.Pawn:
+			sig.pawns[side] = sq
+		}
+	}
+	return sig
+}
+
+// minors counts the knights and bishops of a side
+func (sig *materialSignature) minors(side int) int {
+	return sig.counts[side][engine.Knight] + sig.counts[side][engine.Bishop]
+}
+
+// pieces counts the non-pawn pieces of a side, apart from the king
+func (sig *materialSignature) pieces(side int) int {
+	return sig.minors(side) + sig.counts[side][engine.Rook] + sig.counts[side][engine.Queen]
+}
+
+// only reports whether a side has exactly the given pieces besides its king
+func (sig *materialSignature) only(side int, pawns, knights, bishops, rooks, queens int) bool {
+	c := sig.counts[side]
+	return c[engine.Pawn] == pawns && c[engine.Knight] == knights && c[engine.Bishop] == bishops &&
+		c[engine.Rook] == rooks && c[engine.Queen] == queens
+}
+
+// nonPawnMaterial is the endgame value of a side's pieces
+func (bot *ChessBot) nonPawnMaterial(sig *materialSignature, side int) int {
+	values := bot.params.PieceValues
+	c := sig.counts[side]
+	return c[engine.Knight]*values.Knight.EG + c[engine.Bishop]*values.Bishop.EG +
+		c[engine.Rook]*values.Rook.EG + c[engine.Queen]*values.Queen.EG
+}
+
+// evaluateEndgame adjusts a score from White's point of view with knowledge
+// of endgames the general evaluation gets wrong. The rule used is returned,
+// or an empty string if none applies.
+func (bot *ChessBot) evaluateEndgame(pos *engine.Position, score int) (int, string) {
+	sig := newMaterialSignature(pos)
+
+	// The side that is ahead, from whose point of view the rules are written
+	strong, weak, sign := 0, 1, 1
+	if score < 0 {
+		strong, weak, sign = 1, 0, -1
+	}
+	strongMaterial := bot.nonPawnMaterial(&sig, strong)
+	weakMaterial := bot.nonPawnMaterial(&sig, weak)
+	strongPawns := sig.counts[strong][engine.Pawn]
+
+	switch {
+	// A single minor piece, or two knights, cannot force mate
+	case strongPawns == 0 && sig.counts[strong][engine.Rook]+sig.counts[strong][engine.Queen] == 0 &&
+		(sig.minors(strong) <= 1 || sig.only(strong, 0, 2, 0, 0, 0)):
+		return score / drawScale, EndgameCannotWin
+
+	case sig.pieces(weak) == 0 && sig.counts[weak][engine.Pawn] == 0:
+		return bot.evaluateLoneKing(pos, &sig, strong, score, sign)
+
+	// Without pawns an advantage of less than a minor piece rarely wins, as
+	// in rook against bishop
+	case strongPawns == 0 && strongMaterial-weakMaterial <= bot.params.PieceValues.Knight.EG:
+		return score / drawishScale, EndgameDrawishMaterial
+
+	case sig.only(strong, strongPawns, 0, 1, 0, 0) && sig.only(weak, sig.counts[weak][engine.Pawn], 0, 1, 0, 0) &&
+		squareColor(sig.bishops[strong]) != squareColor(sig.bishops[weak]):
+		if strongPawns-sig.counts[weak][engine.Pawn] <= 1 {
+			return score / oppositeBishopsScaleNear, EndgameOppositeBishops
+		}
+		return score / oppositeBishopsScale, EndgameOppositeBishops
+
+	// With no pawns left to defend, the weak king is driven to the edge
+	case sig.counts[weak][engine.Pawn] == 0 && strongMaterial-weakMaterial >= bot.params.PieceValues.Rook.EG:
+		return score + sign*mopUp(pos, &sig, strong), EndgameMopUp
+	}
+	return score, ""
+}
+
+// evaluateLoneKing handles endgames where the weak side has only its king
+func (bot *ChessBot) evaluateLoneKing(pos *engine.Position, sig *materialSignature, strong, score, sign int) (int, string) {
+	weak := 1 - strong
+	switch {
+	case sig.only(strong, 0, 1, 1, 0, 0):
+		// Mate is only possible in a corner of the bishop's color. The
+		// distance from the long diagonal between the light corners grows
+		// towards the dark corners a1 and h8, so the board is mirrored for
+		// a light-squared bishop.
+		weakKing := sig.kings[weak]
+		if squareColor(sig.bishops[strong]) != squareColor(engine.A1) {
+			weakKing ^= 7
+		}
+		corner := abs(7 - int(weakKing/8) - int(weakKing%8))
+		bonus := kbnkCorner*corner + kbnkClose*(7-chebyshevDistance(sig.kings[0], sig.kings[1]))
+		return score + sign*bonus, EndgameKBNK
+
+	case sig.only(strong, 1, 0, 0, 0, 0):
+		return bot.evaluateKPK(pos, sig, strong, score, sign)
+
+	case sig.pieces(strong) > 0:
+		return score + sign*mopUp(pos, sig, strong), EndgameMopUp
+	}
+	return score, ""
+}
+
+// evaluateKPK applies the rule of the square: a pawn the defending king
+// cannot catch promotes, while a rook pawn whose promotion corner the king
+// reaches is a draw
+func (bot *ChessBot) evaluateKPK(pos *engine.Position, sig *materialSignature, strong, score, sign int) (int, string) {
+	weak := 1 - strong
+	pawn := sig.pawns[strong]
+	file, rank := int(pawn%8), int(pawn/8)
+
+	promotion := engine.Square(56 + file)
+	pawnDistance := 7 - rank
+	if strong == 1 {
+		promotion = engine.Square(file)
+		pawnDistance = rank
+	}
+	// A pawn on its starting rank can advance two squares at once
+	if pawnDistance == 6 {
+		pawnDistance--
+	}
+
+	kingDistance := chebyshevDistance(sig.kings[weak], promotion)
+	if colorIndex(pos.Turn) == weak {
+		kingDistance--
+	}
+
+	// The strong king standing in its pawn's way also slows it down, which
+	// is left to the search
+	ownKingInFront := int(sig.kings[strong]%8) == file &&
+		((strong == 0 && int(sig.kings[strong]/8) > rank) || (strong == 1 && int(sig.kings[strong]/8) < rank))
+
+	switch {
+	case kingDistance > pawnDistance && !ownKingInFront:
+		bonus := bot.params.PieceValues.Queen.EG - bot.params.PieceValues.Pawn.EG - 10*pawnDistance
+		return score + sign*bonus, EndgameKPK
+	case (file == 0 || file == 7) && chebyshevDistance(sig.kings[weak], promotion) <= 1:
+		return score / drawScale, EndgameKPK
+	}
+	return score, ""
+}
+
+// mopUp rewards pushing the weak king to the edge, confining it to a small
+// area and bringing the strong king close to it
+func mopUp(pos *engine.Position, sig *materialSignature, strong int) int {
+	weakKing := sig.kings[1-strong]
+	return mopUpCenter*centerDistance(weakKing) +
+		mopUpClose*(14-manhattanDistance(sig.kings[0], sig.kings[1])) +
+		mopUpArea*(64-kingArea(pos, weakKing))
+}
+
+// kingArea counts the squares a king could walk to if the other side's
+// pieces stood still
+func kingArea(pos *engine.Position, king engine.Square) int {
+	color := pos.Board[king].Color()
+	var visited [64]bool
+	visited[king] = true
+	queue := []engine.Square{king}
+	area := 1
+	for len(queue) > 0 {
+		sq := queue[0]
+		queue = queue[1:]
+		file, rank := int(sq%8), int(sq/8)
+		for df := -1; df <= 1; df++ {
+			for dr := -1; dr <= 1; dr++ {
+				f, r := file+df, rank+dr
+				if f < 0 || f > 7 || r < 0 || r > 7 {
+					continue
+				}
+				next := engine.Square(r*8 + f)
+				if visited[next] {
+					continue
+				}
+				visited[next] = true
+				piece := pos.Board[next]
+				if (piece != engine.Empty && piece.Color() == color) || engine.IsSquareAttacked(pos, next, color.Opponent()) {
+					continue
+				}
+				queue = append(queue, next)
+				area++
+			}
+		}
+	}
+	return area
+}
+
+// centerDistance is the Manhattan distance of a square to the nearest of the
+// four center squares
+func centerDistance(sq engine.Square) int {
+	file, rank := int(sq%8), int(sq/8)
+	return max(3-file, file-4) + max(3-rank, rank-4)
+}
+
+func manhattanDistance(a, b engine.Square) int {
+	return abs(int(a%8)-int(b%8)) + abs(int(a/8)-int(b/8))
+}
+
+func chebyshevDistance(a, b engine.Square) int {
+	return max(abs(int(a%8)-int(b%8)), abs(int(a/8)-int(b/8)))
+}
+
+// squareColor is 0 for dark squares and 1 for light squares
+func squareColor(sq engine.Square) int {
+	return (int(sq%8) + int(sq/8) + 1) % 2
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"errors"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Search depths of hints and threats. They are kept low since both are
+// requested during games and only need to see short tactics.
+const (
+	hintDepth   = 4
+	threatDepth = 3
+	threatLines = 3
+)
+
+// Plies of a line looked at to see whether it wins material
+const materialPlies = 4
+
+// Reasons given for hints and threats
+const (
+	ReasonDeliversMate  = "delivers_mate"
+	ReasonForcesMate    = "forces_mate"
+	ReasonAvoidsMate    = "avoids_mate"
+	ReasonWinsMaterial  = "wins_material"
+	ReasonSavesMaterial = "saves_material"
+	ReasonPromotes      = "promotes"
+	ReasonCastles       = "castles"
+	ReasonDevelops      = "develops_piece"
+	ReasonGivesCheck    = "gives_check"
+	ReasonImproves      = "improves_position"
+)
+
+// ErrGameOver is returned for hints and threats in finished positions
+var ErrGameOver = errors.New("the game is over")
+
+// hintBot computes hints and threats with the default weights
+var hintBot = NewChessBot(hintDepth)
+
+// Hint is a suggested move with the reason it was chosen
+type Hint struct {
+	Move   string   `json:"move"` // Coordinate notation
+	SAN    string   `json:"san"`
+	Reason string   `json:"reason"`
+	Score  Score    `json:"score"`
+	PV     []string `json:"pv"` // SAN
+}
+
+// Threat is a move the opponent could play if it were their turn
+type Threat struct {
+	Move   string   `json:"move"` // Coordinate notation
+	SAN    string   `json:"san"`
+	Reason string   `json:"reason"`
+	Score  Score    `json:"score"` // As if the opponent were to move
+	PV     []string `json:"pv"`    // SAN
+}
+
+// ThreatsResult lists what the opponent threatens. A side in check is shown
+// the check itself rather than further threats.
+type ThreatsResult struct {
+	FEN     string   `json:"fen"`
+	InCheck bool     `json:"inCheck"`
+	Threats []Threat `json:"threats"`
+}
+
+// GetHint suggests a move for the side to move
+func GetHint(pos *engine.Position) (*Hint, error) {
+	if pos.GetGameStatus() != engine.InProgress {
+		return nil, ErrGameOver
+	}
+
+	analysis := hintBot.Analyze(pos, AnalysisOptions{Depth: hintDepth, MultiPV: 1})
+	if len(analysis.Lines) == 0 {
+		return nil, ErrGameOver
+	}
+	line := analysis.Lines[0]
+	move := line.Moves[0]
+
+	return &Hint{
+		Move:   move.String(),
+		SAN:    line.PV[0],
+		Reason: hintReason(pos, line),
+		Score:  line.Score,
+		PV:     line.PV,
+	}, nil
+}
+
+// hintReason names the main idea of the best line of a position
+func hintReason(pos *engine.Position, line AnalysisLine) string {
+	move := line.Moves[0]
+	next := engine.ApplyMove(pos, move)
+	score := line.Score.Centipawns()
+	if pos.Turn == engine.Black {
+		score = -score
+	}
+
+	switch {
+	case next.GetGameStatus() == engine.Checkmate:
+		return ReasonDeliversMate
+	case line.Score.Mate != nil && score > 0:
+		return ReasonForcesMate
+	}
+
+	// The strongest threat comes first
+	threat := ""
+	if threats := findThreats(pos); len(threats) > 0 {
+		threat = threats[0].Reason
+	}
+	pawn := defaultEvalParams.PieceValues.Pawn.MG
+	gain := materialGain(pos, line.Moves)
+
+	switch {
+	case threat == ReasonDeliversMate || threat == ReasonForcesMate:
+		return ReasonAvoidsMate
+	case move.Promotion != engine.NoPieceType:
+		return ReasonPromotes
+	case gain >= pawn:
+		return ReasonWinsMaterial
+	case threat == ReasonWinsMaterial && gain > -pawn:
+		return ReasonSavesMaterial
+	case move.IsCastling:
+		return ReasonCastles
+	case isDevelopingMove(pos, move):
+		return ReasonDevelops
+	case engine.IsKingInCheck(next, next.Turn):
+		return ReasonGivesCheck
+	}
+	return ReasonImproves
+}
+
+// GetThreats shows what the opponent could do if the side to move passed.
+// The opponent's best replies to a null move are searched and those that
+// mate, win material or give check are returned.
+func GetThreats(pos *engine.Position) (*ThreatsResult, error) {
+	if pos.GetGameStatus() != engine.InProgress {
+		return nil, ErrGameOver
+	}
+	result := &ThreatsResult{FEN: pos.String(), Threats: []Threat{}}
+	if engine.IsKingInCheck(pos, pos.Turn) {
+		result.InCheck = true
+		return result, nil
+	}
+	result.Threats = append(result.Threats, findThreats(pos)...)
+	return result, nil
+}
+
+// findThreats searches the opponent's best moves after a null move. It
+// returns nothing when the side to move is in check.
+func findThreats(pos *engine.Position) []Threat {
+	if engine.IsKingInCheck(pos, pos.Turn) {
+		return nil
+	}
+	null := engine.ApplyNullMove(pos)
+	if null.GetGameStatus() != engine.InProgress {
+		return nil
+	}
+
+	analysis := hintBot.Analyze(null, AnalysisOptions{Depth: threatDepth, MultiPV: threatLines})
+	var threats []Threat
+	for _, line := range analysis.Lines {
+		move := line.Moves[0]
+		next := engine.ApplyMove(null, move)
+		score := line.Score.Centipawns()
+		if null.Turn == engine.Black {
+			score = -score
+		}
+
+		var reason string
+		switch {
+		case next.GetGameStatus() == engine.Checkmate:
+			reason = ReasonDeliversMate
+		case line.Score.Mate != nil && score > 0:
+			reason = ReasonForcesMate
+		case materialGain(null, line.Moves) >= defaultEvalParams.PieceValues.Pawn.MG:
+			reason = ReasonWinsMaterial
+		case engine.IsKingInCheck(next, next.Turn):
+			reason = ReasonGivesCheck
+		default:
+			continue
+		}
+		threats = append(threats, Threat{
+			Move:   move.String(),
+			SAN:    line.PV[0],
+			Reason: reason,
+			Score:  line.Score,
+			PV:     line.PV,
+		})
+	}
+	return threats
+}
+
+// materialGain is the material the side to move wins in the first plies of a
+// line, in centipawns
+func materialGain(pos *engine.Position, line []engine.Move) int {
+	before := materialBalance(pos, pos.Turn)
+	after := pos
+	for _, move := range line[:min(len(line), materialPlies)] {
+		after = engine.ApplyMove(after, move)
+	}
+	return materialBalance(after, pos.Turn) - before
+}
+
+// materialBalance counts material from the point of view of a color
+func materialBalance(pos *engine.Position, color engine.Color) int {
+	balance := 0
+	for sq := engine.A1; sq <= engine.H8; sq++ {
+		piece := pos.Board[sq]
+		if piece == engine.Empty || piece.Type() == engine.King {
+			continue
+		}
+		value := defaultEvalParams.PieceValues.Value(piece.Type()).MG
+		if piece.Color() == color {
+			balance += value
+		} else {
+			balance -= value
+		}
+	}
+	return balance
+}
+
+// isDevelopingMove reports whether a move brings a knight or bishop off its
+// home rank
+func isDevelopingMove(pos *engine.Position, move engine.Move) bool {
+	piece := pos.Board[move.From]
+	if piece.Type() != engine.Knight && piece.Type() != engine.Bishop {
+		return false
+	}
+	homeRank := 0
+	if piece.Color() == engine.Black {
+		homeRank = 7
+	}
+	return int(move.From/8) == homeRank && int(move.To/8) != homeRank
+}
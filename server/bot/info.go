@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// SearchInfo reports the progress of a search after a completed depth
+type SearchInfo struct {
+	Depth   int      `json:"depth"`
+	MultiPV int      `json:"multiPv"` // Number of the line, from 1
+	Score   Score    `json:"score"`
+	PV      []string `json:"pv"`    // SAN
+	PVUCI   []string `json:"pvUci"` // Coordinate notation
+	Nodes   int64    `json:"nodes"` // Of the main thread
+	NPS     int64    `json:"nps"`
+	TimeMs  int64    `json:"timeMs"`
+}
+
+// SearchControl lets a caller follow a search while it runs and stop it.
+// Both fields are optional.
+type SearchControl struct {
+	// Info is called on the searching goroutine after every completed depth
+	Info func(SearchInfo)
+
+	// Closing Stop ends the search with the result of the last completed
+	// depth. The first depth always completes so there is a move to play.
+	Stop <-chan struct{}
+}
+
+// control attaches ctl to the searcher. The returned function must be called
+// once the search is over.
+func (s *searcher) control(ctl SearchControl) func() {
+	s.info = ctl.Info
+	if ctl.Stop == nil {
+		return func() {}
+	}
+
+	s.interrupt = new(atomic.Bool)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctl.Stop:
+			s.interrupt.Store(true)
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// armStop lets the time limit and the caller stop the search. It is called
+// once the first iteration completed.
+func (s *searcher) armStop() {
+	if s.timeLimit > 0 {
+		s.deadline = s.start.Add(s.timeLimit)
+	}
+	s.interruptible = s.interrupt != nil
+}
+
+// report passes a completed line to the info callback, if any
+func (s *searcher) report(pos *engine.Position, depth, multiPV, score int, pv []engine.Move) {
+	if s.info == nil || len(pv) == 0 {
+		return
+	}
+
+	uci := make([]string, len(pv))
+	for i, move := range pv {
+		uci[i] = move.String()
+	}
+	elapsed := time.Since(s.start)
+	info := SearchInfo{
+		Depth:   depth,
+		MultiPV: multiPV,
+		Score:   NewScore(score, pos.Turn),
+		PV:      engine.MovesToSAN(pos, pv),
+		PVUCI:   uci,
+		Nodes:   s.stats.Nodes,
+		TimeMs:  elapsed.Milliseconds(),
+	}
+	if elapsed > 0 {
+		info.NPS = int64(float64(s.stats.Nodes) / elapsed.Seconds())
+	}
+	s.info(info)
+}
@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Level describes a named playing strength of the bot
+type Level struct {
+	ID            int           `json:"id"`
+	Name          string        `json:"name"`
+	Depth         int           `json:"depth"`
+	MoveTime      time.Duration `json:"moveTime"`      // 0 means no time limit
+	EvalNoise     int           `json:"evalNoise"`     // Random noise in centipawns added to root move scores
+	BlunderChance float64       `json:"blunderChance"` // Probability of playing a random non-best move
+}
+
+// DefaultLevel is used when a request does not specify a level. It matches
+// the strength of the bot before levels were introduced.
+const DefaultLevel = 8
+
+// Levels lists the available bot levels from weakest to strongest. Their
+// relative strength can be measured with cmd/calibrate; no ratings are shown
+// until a run with enough games per pair gives stable numbers.
+var Levels = []Level{
+	{ID: 1, Name: "Newcomer", Depth: 1, EvalNoise: 300, BlunderChance: 0.35},
+	{ID: 2, Name: "Beginner", Depth: 1, EvalNoise: 200, BlunderChance: 0.20},
+	{ID: 3, Name: "Novice", Depth: 2, EvalNoise: 150, BlunderChance: 0.15},
+	{ID: 4, Name: "Casual", Depth: 2, EvalNoise: 100, BlunderChance: 0.10},
+	{ID: 5, Name: "Club", Depth: 3, EvalNoise: 60, BlunderChance: 0.05},
+	{ID: 6, Name: "Intermediate", Depth: 3, EvalNoise: 30, BlunderChance: 0.02},
+	{ID: 7, Name: "Advanced", Depth: 4, EvalNoise: 15, BlunderChance: 0.01},
+	{ID: 8, Name: "Expert", Depth: 4},
+	{ID: 9, Name: "Master", Depth: 5, MoveTime: 3 * time.Second},
+	{ID: 10, Name: "Grandmaster", Depth: 6, MoveTime: 5 * time.Second},
+}
+
+// GetLevel returns the level with the given ID
+func GetLevel(id int) (Level, bool) {
+	for _, level := range Levels {
+		if level.ID == id {
+			return level, true
+		}
+	}
+	return Level{}, false
+}
+
+// NewChessBotForLevel creates a chess bot playing at the given level
+func NewChessBotForLevel(level Level) *ChessBot {
+	bot := NewChessBot(level.Depth)
+	bot.moveTime = level.MoveTime
+	bot.evalNoise = level.EvalNoise
+	bot.blunderChance = level.BlunderChance
+	return bot
+}
+
+type botKey struct {
+	level       int
+	personality string
+}
+
+var (
+	botsMu sync.Mutex
+	bots   = make(map[botKey]*ChessBot)
+)
+
+// GetBot returns the shared bot for a level and personality, creating it on
+// first use. Zero values select DefaultLevel and DefaultPersonality.
+func GetBot(levelID int, personality string) (*ChessBot, error) {
+	if levelID == 0 {
+		levelID = DefaultLevel
+	}
+	if personality == "" {
+		personality = DefaultPersonality
+	}
+
+	level, ok := GetLevel(levelID)
+	if !ok {
+		return nil, fmt.Errorf("unknown bot level: %d", levelID)
+	}
+	params, ok := GetPersonality(personality)
+	if !ok {
+		return nil, fmt.Errorf("unknown bot personality: %s", personality)
+	}
+
+	botsMu.Lock()
+	defer botsMu.Unlock()
+	key := botKey{level: levelID, personality: personality}
+	if bot, ok := bots[key]; ok {
+		return bot, nil
+	}
+	bot := NewChessBotForLevel(level)
+	bot.SetEvalParams(params)
+	bot.SetThreads(defaultThreads)
+	bots[key] = bot
+	return bot, nil
+}
+
+// LevelsHandler lists the available bot levels
+func LevelsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"levels": Levels, "default": DefaultLevel})
+}
@@ -0,0 +1,231 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of mate searches. The search is exhaustive, so its cost grows
+// quickly with the number of moves.
+const (
+	maxMateMoves = 5
+	maxMateNodes = 5_000_000
+)
+
+// ErrMateSearchLimit is returned when a mate search gives up before proving
+// or refuting the mate
+var ErrMateSearchLimit = errors.New("the mate search exceeded its node limit")
+
+// KeyMove is a first move that forces mate
+type KeyMove struct {
+	Move   string `json:"move"` // Coordinate notation
+	SAN    string `json:"san"`
+	MateIn int    `json:"mateIn"` // Shortest forced mate after this move, in moves
+}
+
+// MateSolution is the result of a mate search. Key moves are sorted by the
+// length of their mate, shortest first.
+type MateSolution struct {
+	FEN      string    `json:"fen"`
+	Moves    int       `json:"moves"` // Mate in this many moves or fewer was searched
+	Mate     bool      `json:"mate"`
+	KeyMoves []KeyMove `json:"keyMoves"`
+	Unique   bool      `json:"unique"` // Exactly one key move
+	Nodes    int64     `json:"nodes"`
+}
+
+// mateKey identifies a proven or refuted node
+type mateKey struct {
+	hash     uint64
+	moves    int
+	defender bool // The defending side is to move
+}
+
+// mateSolver runs an exhaustive AND-OR search: the attacking side needs one
+// move after which every defence is mated, and the defending side needs one
+// reply that escapes
+type mateSolver struct {
+	nodes   int64
+	results map[mateKey]bool
+}
+
+// SolveMate proves or refutes that the side to move forces checkmate in n
+// moves or fewer, and returns every first move that does. Draws by the fifty
+// move rule count as escapes, while repetitions cannot help the defence
+// within a bounded number of moves and are ignored.
+func SolveMate(pos *engine.Position, n int) (*MateSolution, error) {
+	if n < 1 || n > maxMateMoves {
+		return nil, fmt.Errorf("mate searches are limited to 1 to %d moves", maxMateMoves)
+	}
+	if pos.GetGameStatus() != engine.InProgress {
+		return nil, ErrGameOver
+	}
+
+	s := &mateSolver{results: make(map[mateKey]bool)}
+	solution := &MateSolution{FEN: pos.String(), Moves: n, KeyMoves: []KeyMove{}}
+
+	for _, child := range s.children(pos, pos.GenerateLegalMoves()) {
+		// Iterating the length finds the shortest mate of each key move, and
+		// the shorter searches fill the cache for the longer ones
+		for moves := 1; moves <= n; moves++ {
+			mated, err := s.defenderLoses(child.pos, moves)
+			if err != nil {
+				return nil, err
+			}
+			if mated {
+				solution.KeyMoves = append(solution.KeyMoves, KeyMove{
+					Move:   child.move.String(),
+					SAN:    engine.MoveToSAN(pos, child.move),
+					MateIn: moves,
+				})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(solution.KeyMoves, func(i, j int) bool {
+		return solution.KeyMoves[i].MateIn < solution.KeyMoves[j].MateIn
+	})
+	solution.Mate = len(solution.KeyMoves) > 0
+	solution.Unique = len(solution.KeyMoves) == 1
+	solution.Nodes = s.nodes
+	return solution, nil
+}
+
+// mateChild is a move together with the position it leads to
+type mateChild struct {
+	move    engine.Move
+	pos     *engine.Position
+	isCheck bool
+}
+
+// children applies every legal move, ordering checks first and captures and
+// promotions second. Forcing moves are the likely mates for the attacker and
+// the likely escapes for the defender.
+func (s *mateSolver) children(pos *engine.Position, moves []engine.Move) []mateChild {
+	children := make([]mateChild, len(moves))
+	for i, move := range moves {
+		child := engine.ApplyMove(pos, move)
+		children[i] = mateChild{move: move, pos: child, isCheck: engine.IsKingInCheck(child, child.Turn)}
+	}
+
+	rank := func(c mateChild) int {
+		switch {
+		case c.isCheck:
+			return 0
+		case isTactical(c.move):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(children, func(i, j int) bool { return rank(children[i]) < rank(children[j]) })
+	return children
+}
+
+// attackerWins reports whether the side to move mates in at most n moves
+func (s *mateSolver) attackerWins(pos *engine.Position, n int) (bool, error) {
+	key := mateKey{hash: pos.Hash(), moves: n}
+	if result, ok := s.results[key]; ok {
+		return result, nil
+	}
+	if err := s.visit(); err != nil {
+		return false, err
+	}
+
+	result := false
+	for _, child := range s.children(pos, pos.GenerateLegalMoves()) {
+		// The last move has to give check
+		if n == 1 && !child.isCheck {
+			break
+		}
+		mated, err := s.defenderLoses(child.pos, n)
+		if err != nil {
+			return false, err
+		}
+		if mated {
+			result = true
+			break
+		}
+	}
+	s.results[key] = result
+	return result, nil
+}
+
+// defenderLoses reports whether the side to move, which has just received
+// the attacker's move, is mated at once or by the attacker's n-1 further moves
+func (s *mateSolver) defenderLoses(pos *engine.Position, n int) (bool, error) {
+	key := mateKey{hash: pos.Hash(), moves: n, defender: true}
+	if result, ok := s.results[key]; ok {
+		return result, nil
+	}
+	if err := s.visit(); err != nil {
+		return false, err
+	}
+
+	moves := pos.GenerateLegalMoves()
+	if len(moves) == 0 {
+		return engine.IsKingInCheck(pos, pos.Turn), nil
+	}
+	if n == 1 || pos.HalfMoveClock >= 100 {
+		return false, nil
+	}
+
+	result := true
+	for _, child := range s.children(pos, moves) {
+		wins, err := s.attackerWins(child.pos, n-1)
+		if err != nil {
+			return false, err
+		}
+		if !wins {
+			result = false
+			break
+		}
+	}
+	s.results[key] = result
+	return result, nil
+}
+
+func (s *mateSolver) visit() error {
+	s.nodes++
+	if s.nodes > maxMateNodes {
+		return ErrMateSearchLimit
+	}
+	return nil
+}
+
+// MateHandler checks a position for forced mates. The position is given by
+// the fen parameter (the starting position if empty) and optional comma
+// separated moves played from it; n is the number of moves to mate in.
+func MateHandler(c *gin.Context) {
+	var moves []string
+	if list := c.Query("moves"); list != "" {
+		moves = strings.Split(list, ",")
+	}
+	pos, err := positionFromRequest(c.Query("fen"), moves)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	n, err := strconv.Atoi(c.Query("n"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid number of moves"})
+		return
+	}
+
+	solution, err := SolveMate(pos, n)
+	switch {
+	case errors.Is(err, ErrMateSearchLimit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, solution)
+	}
+}
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"sort"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Move ordering scores. Each class of move is searched before the next one.
+const (
+	pvMoveScore     = 1 << 30
+	hashMoveScore   = pvMoveScore - 1
+	captureScore    = 1 << 20
+	killerScore     = 1 << 19
+	historyMaxScore = killerScore - 1
+)
+
+// orderMoves sorts moves so that the most promising ones are searched first:
+// the PV move from the previous iteration, then the transposition table move,
+// then captures and promotions by MVV-LVA, then killer moves, then quiet moves
+// by their history score
+func (s *searcher) orderMoves(pos *engine.Position, moves []engine.Move, ply int, hashMove engine.Move) {
+	if !s.bot.moveOrdering {
+		return
+	}
+
+	var pvMove engine.Move
+	hasPVMove := s.followPV && ply < len(s.prevPV)
+	if hasPVMove {
+		pvMove = s.prevPV[ply]
+	} else {
+		s.followPV = false
+	}
+
+	scores := make([]int, len(moves))
+	for i, move := range moves {
+		switch {
+		case hasPVMove && sameMove(move, pvMove):
+			scores[i] = pvMoveScore
+		case sameMove(move, hashMove):
+			scores[i] = hashMoveScore
+		case isTactical(move):
+			scores[i] = captureScore + s.mvvLva(pos, move)
+		case sameMove(move, s.killers[ply][0]):
+			scores[i] = killerScore + 1
+		case sameMove(move, s.killers[ply][1]):
+			scores[i] = killerScore
+		default:
+			scores[i] = min(s.history[colorIndex(pos.Turn)][move.From][move.To], historyMaxScore)
+		}
+	}
+
+	sort.Sort(&moveSorter{moves: moves, scores: scores})
+}
+
+// mvvLva scores a capture by the value of the victim first and the value of
+// the attacker second (Most Valuable Victim - Least Valuable Attacker)
+func (s *searcher) mvvLva(pos *engine.Position, move engine.Move) int {
+	victim := pos.Board[move.To].Type()
+	if move.IsEnPassant {
+		victim = engine.Pawn
+	}
+	attacker := pos.Board[move.From].Type()
+
+	// Middlegame values are precise enough to order captures
+	values := s.bot.params.PieceValues
+	score := values.Value(victim).MG*10 - values.Value(attacker).MG/10
+	if move.Promotion != engine.NoPieceType {
+		score += values.Value(move.Promotion).MG * 10
+	}
+	return score
+}
+
+// recordCutoff updates the killer and history tables after a quiet move
+// caused a beta cutoff
+func (s *searcher) recordCutoff(pos *engine.Position, move engine.Move, depth, ply int) {
+	if isTactical(move) {
+		return
+	}
+
+	if !sameMove(move, s.killers[ply][0]) {
+		s.killers[ply][1] = s.killers[ply][0]
+		s.killers[ply][0] = move
+	}
+
+	s.history[colorIndex(pos.Turn)][move.From][move.To] += depth * depth
+}
+
+// isTactical reports whether a move changes the material balance
+func isTactical(move engine.Move) bool {
+	return move.IsCapture || move.Promotion != engine.NoPieceType
+}
+
+func sameMove(a, b engine.Move) bool {
+	return a.From == b.From && a.To == b.To && a.Promotion == b.Promotion
+}
+
+func colorIndex(c engine.Color) int {
+	if c == engine.White {
+		return 0
+	}
+	return 1
+}
+
+// moveSorter sorts moves by descending score
+type moveSorter struct {
+	moves  []engine.Move
+	scores []int
+}
+
+func (ms *moveSorter) Len() int { return len(ms.moves) }
+
+func (ms *moveSorter) Less(i, j int) bool { return ms.scores[i] > ms.scores[j] }
+
+func (ms *moveSorter) Swap(i, j int) {
+	ms.moves[i], ms.moves[j] = ms.moves[j], ms.moves[i]
+	ms.scores[i], ms.scores[j] = ms.scores[j], ms.scores[i]
+}
@@ -0,0 +1,305 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Tapered is an evaluation weight with separate middlegame and endgame
+// values. The evaluation blends the two by the material left on the board. In
+// JSON it is written as [mg, eg], or as a single number used for both.
+type Tapered struct {
+	MG int
+	EG int
+}
+
+// Add returns the sum of two weights
+func (t Tapered) Add(other Tapered) Tapered {
+	return Tapered{MG: t.MG + other.MG, EG: t.EG + other.EG}
+}
+
+// Scale returns the weight multiplied by n
+func (t Tapered) Scale(n int) Tapered {
+	return Tapered{MG: t.MG * n, EG: t.EG * n}
+}
+
+// MarshalJSON writes the weight as [mg, eg]
+func (t Tapered) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]int{t.MG, t.EG})
+}
+
+// UnmarshalJSON reads a weight written as [mg, eg] or as a single number
+func (t *Tapered) UnmarshalJSON(data []byte) error {
+	var pair [2]int
+	if err := json.Unmarshal(data, &pair); err == nil {
+		t.MG, t.EG = pair[0], pair[1]
+		return nil
+	}
+	var value int
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("weight must be a number or [mg, eg]: %s", data)
+	}
+	t.MG, t.EG = value, value
+	return nil
+}
+
+// PieceValues holds the material value of each piece type in centipawns
+type PieceValues struct {
+	Pawn   Tapered `json:"pawn"`
+	Knight Tapered `json:"knight"`
+	Bishop Tapered `json:"bishop"`
+	Rook   Tapered `json:"rook"`
+	Queen  Tapered `json:"queen"`
+	King   Tapered `json:"king"`
+}
+
+// Value returns the value of a piece type
+func (pv PieceValues) Value(pt engine.PieceType) Tapered {
+	switch pt {
+	case engine.Pawn:
+		return pv.Pawn
+	case engine.Knight:
+		return pv.Knight
+	case engine.Bishop:
+		return pv.Bishop
+	case engine.Rook:
+		return pv.Rook
+	case engine.Queen:
+		return pv.Queen
+	case engine.King:
+		return pv.King
+	}
+	return Tapered{}
+}
+
+// PieceSquareTables holds a bonus for every piece type on every square. The
+// tables are written as a board from White's side, so index 0 is A8 and index
+// 63 is H1, and are mirrored for Black.
+type PieceSquareTables struct {
+	Pawn   [64]int `json:"pawn"`
+	Knight [64]int `json:"knight"`
+	Bishop [64]int `json:"bishop"`
+	Rook   [64]int `json:"rook"`
+	Queen  [64]int `json:"queen"`
+	King   [64]int `json:"king"`
+}
+
+// Table returns the table of a piece type
+func (t *PieceSquareTables) Table(pt engine.PieceType) *[64]int {
+	switch pt {
+	case engine.Pawn:
+		return &t.Pawn
+	case engine.Knight:
+		return &t.Knight
+	case engine.Bishop:
+		return &t.Bishop
+	case engine.Rook:
+		return &t.Rook
+	case engine.Queen:
+		return &t.Queen
+	case engine.King:
+		return &t.King
+	}
+	return nil
+}
+
+// EvalParams holds every weight used by the evaluation
+type EvalParams struct {
+	PieceValues PieceValues `json:"pieceValues"`
+
+	MiddleGameTables PieceSquareTables `json:"middleGameTables"`
+	EndGameTables    PieceSquareTables `json:"endGameTables"`
+
+	MobilityWeight     Tapered `json:"mobilityWeight"`     // Per legal move
+	PawnShieldBonus    Tapered `json:"pawnShieldBonus"`    // Per pawn in front of the king
+	KingCenterPenalty  Tapered `json:"kingCenterPenalty"`  // King in the center
+	DoubledPawnPenalty Tapered `json:"doubledPawnPenalty"` // Per extra pawn on a file
+	PassedPawnBonus    Tapered `json:"passedPawnBonus"`    // Per passed pawn
+}
+
+// defaultEvalParams are the weights the bot was originally written with. The
+// endgame values favor advanced pawns and an active king.
+var defaultEvalParams = EvalParams{
+	PieceValues: PieceValues{
+		Pawn:   Tapered{100, 120},
+		Knight: Tapered{320, 300},
+		Bishop: Tapered{330, 320},
+		Rook:   Tapered{500, 530},
+		Queen:  Tapered{900, 930},
+		King:   Tapered{20000, 20000},
+	},
+
+	MiddleGameTables: PieceSquareTables{
+		Pawn: [64]int{
+			0, 0, 0, 0, 0, 0, 0, 0,
+			50, 50, 50, 50, 50, 50, 50, 50,
+			10, 10, 20, 30, 30, 20, 10, 10,
+			5, 5, 10, 25, 25, 10, 5, 5,
+			0, 0, 0, 20, 20, 0, 0, 0,
+			5, -5, -10, 0, 0, -10, -5, 5,
+			5, 10, 10, -20, -20, 10, 10, 5,
+			0, 0, 0, 0, 0, 0, 0, 0,
+		},
+		Knight: [64]int{
+			-50, -40, -30, -30, -30, -30, -40, -50,
+			-40, -20, 0, 0, 0, 0, -20, -40,
+			-30, 0, 10, 15, 15, 10, 0, -30,
+			-30, 5, 15, 20, 20, 15, 5, -30,
+			-30, 0, 15, 20, 20, 15, 0, -30,
+			-30, 5, 10, 15, 15, 10, 5, -30,
+			-40, -20, 0, 5, 5, 0, -20, -40,
+			-50, -40, -30, -30, -30, -30, -40, -50,
+		},
+		Bishop: [64]int{
+			-20, -10, -10, -10, -10, -10, -10, -20,
+			-10, 0, 0, 0, 0, 0, 0, -10,
+			-10, 0, 5, 10, 10, 5, 0, -10,
+			-10, 5, 5, 10, 10, 5, 5, -10,
+			-10, 0, 10, 10, 10, 10, 0, -10,
+			-10, 10, 10, 10, 10, 10, 10, -10,
+			-10, 5, 0, 0, 0, 0, 5, -10,
+			-20, -10, -10, -10, -10, -10, -10, -20,
+		},
+		Rook: [64]int{
+			0, 0, 0, 0, 0, 0, 0, 0,
+			5, 10, 10, 10, 10, 10, 10, 5,
+			-5, 0, 0, 0, 0, 0, 0, -5,
+			-5, 0, 0, 0, 0, 0, 0, -5,
+			-5, 0, 0, 0, 0, 0, 0, -5,
+			-5, 0, 0, 0, 0, 0, 0, -5,
+			-5, 0, 0, 0, 0, 0, 0, -5,
+			0, 0, 0, 5, 5, 0, 0, 0,
+		},
+		Queen: [64]int{
+			-20, -10, -10, -5, -5, -10, -10, -20,
+			-10, 0, 0, 0, 0, 0, 0, -10,
+			-10, 0, 5, 5, 5, 5, 0, -10,
+			-5, 0, 5, 5, 5, 5, 0, -5,
+			0, 0, 5, 5, 5, 5, 0, -5,
+			-10, 5, 5, 5, 5, 5, 0, -10,
+			-10, 0, 5, 0, 0, 0, 0, -10,
+			-20, -10, -10, -5, -5, -10, -10, -20,
+		},
+		King: [64]int{
+			-30, -40, -40, -50, -50, -40, -40, -30,
+			-30, -40, -40, -50, -50, -40, -40, -30,
+			-30, -40, -40, -50, -50, -40, -40, -30,
+			-30, -40, -40, -50, -50, -40, -40, -30,
+			-20, -30, -30, -40, -40, -30, -30, -20,
+			-10, -20, -20, -20, -20, -20, -20, -10,
+			20, 20, 0, 0, 0, 0, 20, 20,
+			20, 30, 10, 0, 0, 10, 30, 20,
+		},
+	},
+
+	EndGameTables: PieceSquareTables{
+		Pawn: [64]int{
+			0, 0, 0, 0, 0, 0, 0, 0,
+			80, 80, 80, 80, 80, 80, 80, 80,
+			50, 50, 50, 50, 50, 50, 50, 50,
+			30, 30, 30, 30, 30, 30, 30, 30,
+			20, 20, 20, 20, 20, 20, 20, 20,
+			10, 10, 10, 10, 10, 10, 10, 10,
+			10, 10, 10, 10, 10, 10, 10, 10,
+			0, 0, 0, 0, 0, 0, 0, 0,
+		},
+		Knight: [64]int{
+			-50, -40, -30, -30, -30, -30, -40, -50,
+			-40, -20, 0, 0, 0, 0, -20, -40,
+			-30, 0, 10, 15, 15, 10, 0, -30,
+			-30, 5, 15, 20, 20, 15, 5, -30,
+			-30, 5, 15, 20, 20, 15, 5, -30,
+			-30, 0, 10, 15, 15, 10, 0, -30,
+			-40, -20, 0, 0, 0, 0, -20, -40,
+			-50, -40, -30, -30, -30, -30, -40, -50,
+		},
+		Bishop: [64]int{
+			-20, -10, -10, -10, -10, -10, -10, -20,
+			-10, 0, 0, 0, 0, 0, 0, -10,
+			-10, 0, 5, 10, 10, 5, 0, -10,
+			-10, 5, 10, 10, 10, 10, 5, -10,
+			-10, 5, 10, 10, 10, 10, 5, -10,
+			-10, 0, 5, 10, 10, 5, 0, -10,
+			-10, 0, 0, 0, 0, 0, 0, -10,
+			-20, -10, -10, -10, -10, -10, -10, -20,
+		},
+		Rook: [64]int{
+			0, 0, 0, 0, 0, 0, 0, 0,
+			10, 10, 10, 10, 10, 10, 10, 10,
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0,
+		},
+		Queen: [64]int{
+			-20, -10, -10, -5, -5, -10, -10, -20,
+			-10, 0, 5, 5, 5, 5, 0, -10,
+			-10, 5, 10, 10, 10, 10, 5, -10,
+			-5, 5, 10, 15, 15, 10, 5, -5,
+			-5, 5, 10, 15, 15, 10, 5, -5,
+			-10, 5, 10, 10, 10, 10, 5, -10,
+			-10, 0, 5, 5, 5, 5, 0, -10,
+			-20, -10, -10, -5, -5, -10, -10, -20,
+		},
+		King: [64]int{
+			-50, -40, -30, -20, -20, -30, -40, -50,
+			-30, -20, -10, 0, 0, -10, -20, -30,
+			-30, -10, 20, 30, 30, 20, -10, -30,
+			-30, -10, 30, 40, 40, 30, -10, -30,
+			-30, -10, 30, 40, 40, 30, -10, -30,
+			-30, -10, 20, 30, 30, 20, -10, -30,
+			-30, -30, 0, 0, 0, 0, -30, -30,
+			-50, -30, -30, -30, -30, -30, -30, -50,
+		},
+	},
+
+	MobilityWeight:     Tapered{10, 5},
+	PawnShieldBonus:    Tapered{10, 0},
+	KingCenterPenalty:  Tapered{20, 0},
+	DoubledPawnPenalty: Tapered{10, 20},
+	PassedPawnBonus:    Tapered{20, 40},
+}
+
+// DefaultEvalParams returns a copy of the default evaluation weights
+func DefaultEvalParams() *EvalParams {
+	params := defaultEvalParams
+	return &params
+}
+
+// ParseEvalParams parses evaluation weights from JSON. Fields missing from the
+// JSON keep their default values, so a file only needs to list the weights it
+// changes.
+func ParseEvalParams(data []byte) (*EvalParams, error) {
+	params := DefaultEvalParams()
+	if err := json.Unmarshal(data, params); err != nil {
+		return nil, fmt.Errorf("invalid evaluation parameters: %w", err)
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// LoadEvalParams reads evaluation weights from a JSON file
+func LoadEvalParams(path string) (*EvalParams, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read evaluation parameters: %w", err)
+	}
+	return ParseEvalParams(data)
+}
+
+// validate rejects weights the search cannot work with
+func (p *EvalParams) validate() error {
+	for _, pt := range []engine.PieceType{engine.Pawn, engine.Knight, engine.Bishop, engine.Rook, engine.Queen, engine.King} {
+		if value := p.PieceValues.Value(pt); value.MG <= 0 || value.EG <= 0 {
+			return fmt.Errorf("invalid evaluation parameters: %s value must be positive", pt.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"embed"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultPersonality is used when a request does not pick a personality
+const DefaultPersonality = "default"
+
+//go:embed personalities/*.json
+var builtinPersonalities embed.FS
+
+var (
+	personalitiesMu sync.RWMutex
+	personalities   = map[string]*EvalParams{DefaultPersonality: DefaultEvalParams()}
+)
+
+func init() {
+	files, err := builtinPersonalities.ReadDir("personalities")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := builtinPersonalities.ReadFile("personalities/" + file.Name())
+		if err != nil {
+			panic(err)
+		}
+		params, err := ParseEvalParams(data)
+		if err != nil {
+			panic(fmt.Sprintf("personality %s: %v", file.Name(), err))
+		}
+		personalities[strings.TrimSuffix(file.Name(), ".json")] = params
+	}
+}
+
+// LoadPersonalities loads every JSON file in dir as a personality named after
+// the file. Personalities with the same name as a built-in one replace it.
+func LoadPersonalities(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*EvalParams)
+	for _, path := range paths {
+		params, err := LoadEvalParams(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		loaded[strings.TrimSuffix(filepath.Base(path), ".json")] = params
+	}
+
+	personalitiesMu.Lock()
+	defer personalitiesMu.Unlock()
+	for name, params := range loaded {
+		personalities[name] = params
+	}
+
+	// Bots built from the old weights must not be reused
+	botsMu.Lock()
+	bots = make(map[botKey]*ChessBot)
+	botsMu.Unlock()
+	return nil
+}
+
+// LoadPersonalitiesFromEnv loads extra personalities from the directory named
+// by BOT_PERSONALITIES_DIR, if set
+func LoadPersonalitiesFromEnv() error {
+	dir := os.Getenv("BOT_PERSONALITIES_DIR")
+	if dir == "" {
+		return nil
+	}
+	return LoadPersonalities(dir)
+}
+
+// GetPersonality returns the evaluation weights of a personality
+func GetPersonality(name string) (*EvalParams, bool) {
+	if name == "" {
+		name = DefaultPersonality
+	}
+	personalitiesMu.RLock()
+	defer personalitiesMu.RUnlock()
+	params, ok := personalities[name]
+	return params, ok
+}
+
+// PersonalityNames returns the names of all personalities in sorted order
+func PersonalityNames() []string {
+	personalitiesMu.RLock()
+	defer personalitiesMu.RUnlock()
+	names := make([]string, 0, len(personalities))
+	for name := range personalities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PersonalitiesHandler lists the available bot personalities
+func PersonalitiesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"personalities": PersonalityNames(), "default": DefaultPersonality})
+}
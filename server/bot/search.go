@@ -0,0 +1,430 @@
+package bot
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+const (
+	// infinity bounds every score the search can return
+	infinity = 1000000
+
+	// mateScore is the score of delivering checkmate at the root. Mates found
+	// deeper in the tree score slightly less so the shortest mate is preferred.
+	mateScore = 100000
+
+	// maxPly is the deepest ply the search keeps per-ply tables for
+	maxPly = 64
+)
+
+// SearchStats holds counters collected during a search
+type SearchStats struct {
+	Nodes            int64         `json:"nodes"`
+	BetaCutoffs      int64         `json:"betaCutoffs"`
+	FirstMoveCutoffs int64         `json:"firstMoveCutoffs"`
+	Depth            int           `json:"depth"`
+	Threads          int           `json:"threads"`
+	Elapsed          time.Duration `json:"elapsed"`
+}
+
+// OrderingEfficiency returns the share of beta cutoffs produced by the first
+// move searched. Values close to 1 mean the move ordering is working well.
+func (s SearchStats) OrderingEfficiency() float64 {
+	if s.BetaCutoffs == 0 {
+		return 0
+	}
+	return float64(s.FirstMoveCutoffs) / float64(s.BetaCutoffs)
+}
+
+// SearchResult is the outcome of a search
+type SearchResult struct {
+	Move  engine.Move
+	Score int // From the perspective of the side to move
+	PV    []engine.Move
+	Stats SearchStats
+}
+
+// searcher holds the state of a single search thread. The bot itself is
+// shared between requests, so everything that changes while searching lives
+// here, apart from the transposition table which all threads share.
+type searcher struct {
+	bot *ChessBot
+	tt  *transpositionTable
+
+	killers [maxPly][2]engine.Move
+	history [2][64][64]int
+
+	pvTable  [maxPly][maxPly]engine.Move
+	pvLength [maxPly]int
+	prevPV   []engine.Move
+	followPV bool
+
+	// excluded root moves are skipped, which is how further lines of a
+	// multi-PV analysis are found
+	excluded []engine.Move
+
+	// Hashes of the positions on the current search path by ply, and of the
+	// root and the game positions before it, most recent first
+	pathHashes [maxPly]uint64
+	gameHashes []uint64
+
+	start     time.Time
+	timeLimit time.Duration
+	deadline  time.Time
+	stop      *atomic.Bool // Shared by all threads of a search
+
+	// A search controlled by a caller reports to info and is interrupted
+	// once interrupt is set, but only after its first iteration
+	info          func(SearchInfo)
+	interrupt     *atomic.Bool
+	interruptible bool
+
+	stats SearchStats
+}
+
+func newSearcher(bot *ChessBot, root *engine.Position, stop *atomic.Bool) *searcher {
+	if stop == nil {
+		stop = new(atomic.Bool)
+	}
+	return &searcher{
+		bot:        bot,
+		tt:         bot.transpositionTable(),
+		gameHashes: append([]uint64{root.Hash()}, root.History()...),
+		start:      time.Now(),
+		stop:       stop,
+	}
+}
+
+// Search runs an iterative deepening search on the position up to the bot's
+// maximum depth and returns the best move found together with its statistics.
+// When the bot has a move time, the search stops early once it is used up and
+// the result of the last completed iteration is returned.
+//
+// Bots with more than one thread run a Lazy SMP search: helper threads
+// borrowed from the global worker budget search the same position and share
+// what they find through the transposition table, while the result always
+// comes from the main thread.
+func (bot *ChessBot) Search(pos *engine.Position) SearchResult {
+	return bot.SearchWithControl(pos, SearchControl{})
+}
+
+// SearchWithControl is Search reporting its progress to ctl.Info after every
+// completed depth. Closing ctl.Stop ends the search early with the result of
+// the last completed depth.
+func (bot *ChessBot) SearchWithControl(pos *engine.Position, ctl SearchControl) SearchResult {
+	start := time.Now()
+	stop := new(atomic.Bool)
+
+	helpers := workerBudget.acquire(bot.threads - 1)
+	defer workerBudget.release(helpers)
+
+	var wg sync.WaitGroup
+	var helperNodes atomic.Int64
+	for id := 1; id <= helpers; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			h := newSearcher(bot, pos, stop)
+			// Odd helpers start one ply deeper so the threads spread out
+			// over different depths instead of racing on the same one
+			h.iterate(pos, 1+id%2, bot.maxDepth+id%2)
+			helperNodes.Add(h.stats.Nodes)
+		}(id)
+	}
+
+	s := newSearcher(bot, pos, stop)
+	s.timeLimit = bot.moveTime
+	defer s.control(ctl)()
+	result := s.iterate(pos, 1, bot.maxDepth)
+
+	stop.Store(true)
+	wg.Wait()
+
+	s.stats.Nodes += helperNodes.Load()
+	s.stats.Threads = helpers + 1
+	s.stats.Elapsed = time.Since(start)
+	result.Stats = s.stats
+	return result
+}
+
+// iterate searches the position with increasing depth until maxDepth is
+// reached or the search is stopped
+func (s *searcher) iterate(pos *engine.Position, minDepth, maxDepth int) SearchResult {
+	var result SearchResult
+	for depth := minDepth; depth <= maxDepth; depth++ {
+		s.followPV = true
+		score := s.negamax(pos, depth, 0, -infinity, infinity)
+		if s.stop.Load() {
+			break
+		}
+
+		result.Score = score
+		result.PV = s.extendPV(pos, s.principalVariation(), depth)
+		s.prevPV = result.PV
+		s.stats.Depth = depth
+		s.report(pos, depth, 1, score, result.PV)
+
+		// The first iteration always completes so there is a move to play
+		if depth == minDepth {
+			s.armStop()
+		}
+	}
+
+	if len(result.PV) > 0 {
+		result.Move = result.PV[0]
+	}
+	return result
+}
+
+// BestMove finds the move the bot plays in the position. Bots with eval
+// noise or a blunder chance pick a deliberately imperfect move instead of the
+// best one.
+func (bot *ChessBot) BestMove(pos *engine.Position) engine.Move {
+	return bot.BestMoveWithControl(pos, SearchControl{})
+}
+
+// BestMoveWithControl is BestMove reporting the progress of the search to
+// ctl.Info and stopping early when ctl.Stop is closed. Imperfect moves are
+// chosen after a search of fixed depth, which is reported once and cannot be
+// stopped.
+func (bot *ChessBot) BestMoveWithControl(pos *engine.Position, ctl SearchControl) engine.Move {
+	if bot.evalNoise > 0 || bot.blunderChance > 0 {
+		return bot.getImperfectMove(pos, ctl.Info)
+	}
+
+	result := bot.SearchWithControl(pos, ctl)
+	log.Printf("Bot chose move %s with evaluation %d (depth %d, %d nodes, %.1f%% first-move cutoffs, %s)",
+		result.Move.String(), result.Score, result.Stats.Depth, result.Stats.Nodes,
+		result.Stats.OrderingEfficiency()*100, result.Stats.Elapsed)
+	return result.Move
+}
+
+// getImperfectMove scores every root move exactly, adds random noise to the
+// scores and occasionally plays a random move instead of the best one
+func (bot *ChessBot) getImperfectMove(pos *engine.Position, info func(SearchInfo)) engine.Move {
+	moves := pos.GenerateLegalMoves()
+	if len(moves) == 0 {
+		return engine.Move{} // No legal moves
+	}
+
+	s := newSearcher(bot, pos, nil)
+	s.info = info
+	// The root children are searched at ply 1, so the root is entered in the
+	// path by hand for lines returning to it to count as repetitions
+	s.pathHashes[0] = pos.Hash()
+	bestIndex := 0
+	bestScore := -infinity
+	for i, move := range moves {
+		newPos := engine.ApplyMove(pos, move)
+		score := -s.negamax(newPos, bot.maxDepth-1, 1, -infinity, infinity)
+		if bot.evalNoise > 0 {
+			score += rand.Intn(2*bot.evalNoise+1) - bot.evalNoise
+		}
+		if score > bestScore {
+			bestScore = score
+			bestIndex = i
+		}
+	}
+
+	s.report(pos, bot.maxDepth, 1, bestScore, []engine.Move{moves[bestIndex]})
+
+	if len(moves) > 1 && rand.Float64() < bot.blunderChance {
+		blunderIndex := rand.Intn(len(moves) - 1)
+		if blunderIndex >= bestIndex {
+			blunderIndex++
+		}
+		log.Printf("Bot blundered with move %s instead of %s", moves[blunderIndex].String(), moves[bestIndex].String())
+		return moves[blunderIndex]
+	}
+
+	log.Printf("Bot chose move %s with noisy evaluation %d (%d nodes)", moves[bestIndex].String(), bestScore, s.stats.Nodes)
+	return moves[bestIndex]
+}
+
+// negamax implements an alpha-beta search where scores are always taken from
+// the perspective of the side to move
+func (s *searcher) negamax(pos *engine.Position, depth, ply int, alpha, beta int) int {
+	s.stats.Nodes++
+	s.pvLength[ply] = ply
+	if s.shouldStop() {
+		return 0
+	}
+
+	switch pos.GetGameStatus() {
+	case engine.InProgress:
+	case engine.Checkmate:
+		return -mateScore + ply
+	default:
+		return 0
+	}
+
+	hash := pos.Hash()
+	s.pathHashes[ply] = hash
+	if ply > 0 && s.isRepetition(pos, hash, ply) {
+		return 0
+	}
+
+	if depth == 0 || ply >= maxPly-1 {
+		return s.bot.evaluatePosition(pos, pos.Turn)
+	}
+
+	var hashMove engine.Move
+	if entry, ok := s.tt.probe(hash); ok {
+		hashMove = entry.move
+		if ply > 0 && entry.depth >= depth {
+			score := scoreFromTT(entry.score, ply)
+			switch {
+			case entry.bound == boundExact,
+				entry.bound == boundLower && score >= beta,
+				entry.bound == boundUpper && score <= alpha:
+				return score
+			}
+		}
+	}
+
+	moves := pos.GenerateLegalMoves()
+	s.orderMoves(pos, moves, ply, hashMove)
+
+	originalAlpha := alpha
+	best := -infinity
+	var bestMove engine.Move
+	for i, move := range moves {
+		if ply == 0 && s.isExcluded(move) {
+			continue
+		}
+		newPos := engine.ApplyMove(pos, move)
+		score := -s.negamax(newPos, depth-1, ply+1, -beta, -alpha)
+
+		// Only the first move of a node can continue the previous PV
+		s.followPV = false
+
+		if score > best {
+			best = score
+			bestMove = move
+		}
+		if score > alpha {
+			alpha = score
+			s.updatePV(ply, move)
+		}
+		if alpha >= beta {
+			s.stats.BetaCutoffs++
+			if i == 0 {
+				s.stats.FirstMoveCutoffs++
+			}
+			s.recordCutoff(pos, move, depth, ply)
+			break // Alpha-beta pruning
+		}
+	}
+
+	// Scores of an interrupted search are meaningless
+	if s.stop.Load() {
+		return 0
+	}
+	// Every root move was excluded
+	if best == -infinity {
+		return best
+	}
+	// The best of the remaining root moves is not the best move of the position
+	if ply == 0 && len(s.excluded) > 0 {
+		return best
+	}
+
+	bound := boundExact
+	if best <= originalAlpha {
+		bound = boundUpper
+	} else if best >= beta {
+		bound = boundLower
+	}
+	s.tt.store(hash, ttData{move: bestMove, score: scoreToTT(best, ply), depth: depth, bound: bound})
+	return best
+}
+
+// isRepetition reports whether a position occurred before, earlier in the
+// search or in the game. A single repetition is scored as a draw, since the
+// side that could avoid it would have done so.
+func (s *searcher) isRepetition(pos *engine.Position, hash uint64, ply int) bool {
+	// Positions further back than the last capture or pawn move cannot repeat
+	for back := 2; back <= pos.HalfMoveClock; back += 2 {
+		var earlier uint64
+		if back < ply {
+			earlier = s.pathHashes[ply-back]
+		} else if back-ply < len(s.gameHashes) {
+			earlier = s.gameHashes[back-ply]
+		} else {
+			return false
+		}
+		if earlier == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldStop reports whether the search was stopped by another thread or ran
+// out of time
+func (s *searcher) shouldStop() bool {
+	if s.stop.Load() {
+		return true
+	}
+	if s.interruptible && s.interrupt.Load() {
+		s.stop.Store(true)
+		return true
+	}
+	if s.stats.Nodes&1023 == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		s.stop.Store(true)
+		return true
+	}
+	return false
+}
+
+// updatePV stores move as the best move at ply followed by the child's PV
+func (s *searcher) updatePV(ply int, move engine.Move) {
+	s.pvTable[ply][ply] = move
+	for next := ply + 1; next < s.pvLength[ply+1]; next++ {
+		s.pvTable[ply][next] = s.pvTable[ply+1][next]
+	}
+	s.pvLength[ply] = s.pvLength[ply+1]
+}
+
+// principalVariation returns a copy of the PV found by the last iteration
+func (s *searcher) principalVariation() []engine.Move {
+	pv := make([]engine.Move, s.pvLength[0])
+	copy(pv, s.pvTable[0][:s.pvLength[0]])
+	return pv
+}
+
+// extendPV continues a PV that was cut short by transposition table cutoffs
+// with the moves stored in the table, up to maxLength moves
+func (s *searcher) extendPV(pos *engine.Position, pv []engine.Move, maxLength int) []engine.Move {
+	for _, move := range pv {
+		pos = engine.ApplyMove(pos, move)
+	}
+
+	for len(pv) < maxLength {
+		entry, ok := s.tt.probe(pos.Hash())
+		if !ok || entry.move.From == entry.move.To {
+			break
+		}
+		move, ok := pos.FindMove(entry.move.From, entry.move.To, entry.move.Promotion)
+		if !ok {
+			break
+		}
+		pv = append(pv, move)
+		pos = engine.ApplyMove(pos, move)
+	}
+	return pv
+}
+
+func (s *searcher) isExcluded(move engine.Move) bool {
+	for _, excluded := range s.excluded {
+		if sameMove(move, excluded) {
+			return true
+		}
+	}
+	return false
+}
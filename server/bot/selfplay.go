@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"math"
+	"strings"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Game results from White's point of view
+const (
+	WhiteWins = "1-0"
+	BlackWins = "0-1"
+	Draw      = "1/2-1/2"
+)
+
+// GameRecord describes a finished bot vs bot game
+type GameRecord struct {
+	StartFEN string
+	Moves    []engine.Move
+	Result   string
+	Reason   string
+}
+
+// PlayGame plays a game between two bots starting from the given position. The
+// game is adjudicated as a draw after maxPlies half-moves.
+func PlayGame(white, black *ChessBot, start *engine.Position, maxPlies int) GameRecord {
+	record := GameRecord{StartFEN: start.String()}
+	pos := start
+	seen := map[string]int{repetitionKey(pos): 1}
+
+	for ply := 0; ply < maxPlies; ply++ {
+		status := pos.GetGameStatus()
+		if status != engine.InProgress {
+			record.Reason = status.String()
+			if status == engine.Checkmate && pos.Turn == engine.White {
+				record.Result = BlackWins
+			} else if status == engine.Checkmate {
+				record.Result = WhiteWins
+			} else {
+				record.Result = Draw
+			}
+			return record
+		}
+
+		player := white
+		if pos.Turn == engine.Black {
+			player = black
+		}
+		move := player.BestMove(pos)
+		pos = engine.ApplyMove(pos, move)
+		record.Moves = append(record.Moves, move)
+
+		key := repetitionKey(pos)
+		seen[key]++
+		if seen[key] >= 3 {
+			record.Result = Draw
+			record.Reason = engine.DrawByRepetition.String()
+			return record
+		}
+	}
+
+	record.Result = Draw
+	record.Reason = "max_plies"
+	return record
+}
+
+// repetitionKey identifies a position for repetition detection, ignoring the
+// move counters in the FEN
+func repetitionKey(pos *engine.Position) string {
+	fields := strings.Fields(pos.String())
+	return strings.Join(fields[:4], " ")
+}
+
+// ResultScore returns the score of a result from White's point of view
+func ResultScore(result string) float64 {
+	switch result {
+	case WhiteWins:
+		return 1
+	case BlackWins:
+		return 0
+	default:
+		return 0.5
+	}
+}
+
+// EloDifference converts an expected score into an Elo difference
+func EloDifference(score float64) float64 {
+	// Keep the result finite for clean sweeps
+	score = math.Max(0.001, math.Min(0.999, score))
+	return -400 * math.Log10(1/score-1)
+}
@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// defaultThreads is the number of search threads given to bots created by
+// getBot. It can be changed with BOT_THREADS.
+var defaultThreads = min(4, runtime.NumCPU())
+
+// workerBudget limits the helper threads running across all searches. Every
+// search runs on its own goroutine and borrows helpers from the budget, so
+// concurrent requests share the machine instead of each using every core.
+var workerBudget = newWorkerPool(runtime.NumCPU())
+
+// workerPool is a counting semaphore that never blocks
+type workerPool struct {
+	mu        sync.Mutex
+	size      int
+	available int
+}
+
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{size: size, available: size}
+}
+
+// acquire takes up to n workers from the pool and returns how many it got
+func (p *workerPool) acquire(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n = max(0, min(n, p.available))
+	p.available -= n
+	return n
+}
+
+// release returns workers to the pool
+func (p *workerPool) release(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.available += n
+}
+
+// resize changes the number of workers in the pool. Workers currently in use
+// are accounted for when they are released.
+func (p *workerPool) resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.available += size - p.size
+	p.size = size
+}
+
+// ConfigureThreadsFromEnv reads BOT_THREADS, the number of search threads
+// per bot, and BOT_WORKER_BUDGET, the number of helper threads shared by all
+// searches. Both must be set before any bot is used.
+func ConfigureThreadsFromEnv() error {
+	if value := os.Getenv("BOT_THREADS"); value != "" {
+		threads, err := strconv.Atoi(value)
+		if err != nil || threads < 1 {
+			return fmt.Errorf("invalid BOT_THREADS: %s", value)
+		}
+		defaultThreads = threads
+	}
+	if value := os.Getenv("BOT_WORKER_BUDGET"); value != "" {
+		budget, err := strconv.Atoi(value)
+		if err != nil || budget < 0 {
+			return fmt.Errorf("invalid BOT_WORKER_BUDGET: %s", value)
+		}
+		workerBudget.resize(budget)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/gin-gonic/gin"
+)
+
+// Names of the evaluation terms in a trace
+const (
+	TermMaterial          = "material"
+	TermPieceSquarePawn   = "piece_square_pawn"
+	TermPieceSquareKnight = "piece_square_knight"
+	TermPieceSquareBishop = "piece_square_bishop"
+	TermPieceSquareRook   = "piece_square_rook"
+	TermPieceSquareQueen  = "piece_square_queen"
+	TermPieceSquareKing   = "piece_square_king"
+	TermMobility          = "mobility"
+	TermKingSafety        = "king_safety"
+	TermPawnStructure     = "pawn_structure"
+	TermPassedPawns       = "passed_pawns"
+)
+
+// traceTerms is the order terms are listed in
+var traceTerms = []string{
+	TermMaterial,
+	TermPieceSquarePawn, TermPieceSquareKnight, TermPieceSquareBishop,
+	TermPieceSquareRook, TermPieceSquareQueen, TermPieceSquareKing,
+	TermMobility, TermKingSafety, TermPawnStructure, TermPassedPawns,
+}
+
+func pieceSquareTerm(pt engine.PieceType) string {
+	switch pt {
+	case engine.Pawn:
+		return TermPieceSquarePawn
+	case engine.Knight:
+		return TermPieceSquareKnight
+	case engine.Bishop:
+		return TermPieceSquareBishop
+	case engine.Rook:
+		return TermPieceSquareRook
+	case engine.Queen:
+		return TermPieceSquareQueen
+	default:
+		return TermPieceSquareKing
+	}
+}
+
+// TermScore is the middlegame and endgame score of a term together with the
+// score blended by the game phase, in centipawns
+type TermScore struct {
+	MG    int `json:"mg"`
+	EG    int `json:"eg"`
+	Score int `json:"score"`
+}
+
+// TraceTerm is one term of the evaluation for both sides. Net is White's
+// score minus Black's.
+type TraceTerm struct {
+	Name  string    `json:"name"`
+	White TermScore `json:"white"`
+	Black TermScore `json:"black"`
+	Net   TermScore `json:"net"`
+}
+
+// EvalTrace breaks the static evaluation of a position down into its terms.
+// Terms are blended by the phase one by one, so their scores may add up to
+// the total with a rounding difference of a few centipawns. Known endgames
+// then adjust the total into the final score.
+type EvalTrace struct {
+	FEN      string      `json:"fen"`
+	Phase    int         `json:"phase"` // From MaxPhase with all pieces on the board down to 0
+	MaxPhase int         `json:"maxPhase"`
+	Terms    []TraceTerm `json:"terms"`
+	Total    TermScore   `json:"total"`             // From White's point of view
+	Endgame  string      `json:"endgame,omitempty"` // Endgame rule applied to the total
+	Score    int         `json:"score"`             // Final score from White's point of view
+}
+
+// add records the score of a term for one side. It does nothing on a nil
+// trace, which is how the search evaluates.
+func (t *EvalTrace) add(term string, color engine.Color, value Tapered) {
+	if t == nil {
+		return
+	}
+	for i := range t.Terms {
+		if t.Terms[i].Name != term {
+			continue
+		}
+		side := &t.Terms[i].White
+		if color == engine.Black {
+			side = &t.Terms[i].Black
+		}
+		side.MG += value.MG
+		side.EG += value.EG
+		return
+	}
+}
+
+// TraceEvaluation evaluates a position and returns every term separately
+func (bot *ChessBot) TraceEvaluation(pos *engine.Position) *EvalTrace {
+	trace := &EvalTrace{FEN: pos.String(), Phase: gamePhase(pos), MaxPhase: maxPhase}
+	for _, name := range traceTerms {
+		trace.Terms = append(trace.Terms, TraceTerm{Name: name})
+	}
+
+	total := bot.evaluateTerms(pos, trace)
+
+	blend := func(mg, eg int) TermScore {
+		return TermScore{MG: mg, EG: eg, Score: taper(Tapered{MG: mg, EG: eg}, trace.Phase)}
+	}
+	for i := range trace.Terms {
+		term := &trace.Terms[i]
+		term.White = blend(term.White.MG, term.White.EG)
+		term.Black = blend(term.Black.MG, term.Black.EG)
+		term.Net = blend(term.White.MG-term.Black.MG, term.White.EG-term.Black.EG)
+	}
+	trace.Total = blend(total.MG, total.EG)
+	trace.Score, trace.Endgame = bot.evaluateEndgame(pos, trace.Total.Score)
+	return trace
+}
+
+// EvalTraceHandler returns the evaluation breakdown of a position. The
+// position is given by the fen parameter (the starting position if empty)
+// and optional comma separated moves played from it; the personality
+// parameter selects the weights.
+func EvalTraceHandler(c *gin.Context) {
+	var moves []string
+	if list := c.Query("moves"); list != "" {
+		moves = strings.Split(list, ",")
+	}
+	pos, err := positionFromRequest(c.Query("fen"), moves)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	params, ok := GetPersonality(c.Query("personality"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown personality"})
+		return
+	}
+	bot := NewChessBot(0)
+	bot.SetEvalParams(params)
+
+	c.JSON(http.StatusOK, bot.TraceEvaluation(pos))
+}
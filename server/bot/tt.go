@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"sync/atomic"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Bound types of a transposition table score
+const (
+	boundExact = iota + 1
+	boundLower // Score is at least the stored value (beta cutoff)
+	boundUpper // Score is at most the stored value (failed low)
+)
+
+// defaultTTEntries is the number of entries allocated per bot (16 bytes each)
+const defaultTTEntries = 1 << 18
+
+// ttEntry packs a search result into one word. The key word holds the
+// position hash XORed with the data word, so an entry torn by a concurrent
+// write fails verification instead of returning another position's data.
+type ttEntry struct {
+	key  atomic.Uint64
+	data atomic.Uint64
+}
+
+// transpositionTable is a fixed-size hash table shared by every search
+// thread of a bot. It takes no locks: readers verify entries as described on
+// ttEntry and writers simply overwrite.
+type transpositionTable struct {
+	entries []ttEntry
+	mask    uint64
+}
+
+// newTranspositionTable allocates a table, rounding size down to a power of two
+func newTranspositionTable(size int) *transpositionTable {
+	n := 1
+	for n*2 <= size {
+		n *= 2
+	}
+	return &transpositionTable{entries: make([]ttEntry, n), mask: uint64(n - 1)}
+}
+
+// ttData is an unpacked table entry
+type ttData struct {
+	move  engine.Move // Only From, To and Promotion are stored
+	score int
+	depth int
+	bound int
+}
+
+// Data word layout:
+//
+//	bits  0-31 score (int32)
+//	bits 32-37 move from
+//	bits 38-43 move to
+//	bits 44-46 promotion piece type
+//	bit     47 has move
+//	bits 48-55 depth
+//	bits 56-57 bound
+func packTTData(d ttData) uint64 {
+	data := uint64(uint32(int32(d.score)))
+	if d.move.From != d.move.To {
+		data |= uint64(d.move.From) << 32
+		data |= uint64(d.move.To) << 38
+		data |= uint64(d.move.Promotion) << 44
+		data |= 1 << 47
+	}
+	data |= uint64(d.depth&0xFF) << 48
+	data |= uint64(d.bound&0x3) << 56
+	return data
+}
+
+func unpackTTData(data uint64) ttData {
+	d := ttData{
+		score: int(int32(uint32(data))),
+		depth: int((data >> 48) & 0xFF),
+		bound: int((data >> 56) & 0x3),
+	}
+	if data&(1<<47) != 0 {
+		d.move = engine.Move{
+			From:      engine.Square((data >> 32) & 0x3F),
+			To:        engine.Square((data >> 38) & 0x3F),
+			Promotion: engine.PieceType((data >> 44) & 0x7),
+		}
+	}
+	return d
+}
+
+// probe looks up a position
+func (tt *transpositionTable) probe(hash uint64) (ttData, bool) {
+	entry := &tt.entries[hash&tt.mask]
+	key := entry.key.Load()
+	data := entry.data.Load()
+	if key^data != hash {
+		return ttData{}, false
+	}
+	return unpackTTData(data), true
+}
+
+// store saves a search result. An entry for the same position is only
+// replaced by a search of at least the same depth; entries for other
+// positions are always replaced.
+func (tt *transpositionTable) store(hash uint64, d ttData) {
+	if old, ok := tt.probe(hash); ok && old.depth > d.depth {
+		return
+	}
+	entry := &tt.entries[hash&tt.mask]
+	data := packTTData(d)
+	entry.data.Store(data)
+	entry.key.Store(hash ^ data)
+}
+
+// Mate scores depend on the distance from the root, so they are stored
+// relative to the node instead
+func scoreToTT(score, ply int) int {
+	if score > mateScore-maxPly {
+		return score + ply
+	}
+	if score < -mateScore+maxPly {
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTT(score, ply int) int {
+	if score > mateScore-maxPly {
+		return score - ply
+	}
+	if score < -mateScore+maxPly {
+		return score + ply
+	}
+	return score
+}
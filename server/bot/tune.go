@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// TuningPosition is a position together with the result of the game it was
+// taken from
+type TuningPosition struct {
+	Pos    *engine.Position
+	Result float64 // 1 if White won, 0.5 for a draw, 0 if Black won
+}
+
+// TuneOptions controls a tuning run
+type TuneOptions struct {
+	Passes      int // Passes over all weights; tuning also stops when a pass changes nothing
+	Step        int // Amount a weight is moved by in one try
+	Concurrency int // Goroutines extracting features, defaults to the number of CPUs
+
+	// Progress is called after each pass with the mean squared error
+	Progress func(pass int, err float64)
+}
+
+// TuneResult is the outcome of a tuning run
+type TuneResult struct {
+	Params       *EvalParams
+	K            float64 // Scaling of the evaluation into a winning probability
+	InitialError float64
+	Error        float64
+}
+
+// tunable is a weight of EvalParams changed by the tuner
+type tunable struct {
+	value   *int
+	endgame bool
+}
+
+// tunables lists the weights of the parameters the tuner may change. King
+// values are left out since both sides always have one.
+func (p *EvalParams) tunables() []tunable {
+	var weights []tunable
+	add := func(t *Tapered) {
+		weights = append(weights, tunable{value: &t.MG}, tunable{value: &t.EG, endgame: true})
+	}
+
+	for _, t := range []*Tapered{&p.PieceValues.Pawn, &p.PieceValues.Knight, &p.PieceValues.Bishop, &p.PieceValues.Rook, &p.PieceValues.Queen} {
+		add(t)
+	}
+	for _, pt := range []engine.PieceType{engine.Pawn, engine.Knight, engine.Bishop, engine.Rook, engine.Queen, engine.King} {
+		mg, eg := p.MiddleGameTables.Table(pt), p.EndGameTables.Table(pt)
+		for i := range mg {
+			weights = append(weights, tunable{value: &mg[i]}, tunable{value: &eg[i], endgame: true})
+		}
+	}
+	for _, t := range []*Tapered{&p.MobilityWeight, &p.PawnShieldBonus, &p.KingCenterPenalty, &p.DoubledPawnPenalty, &p.PassedPawnBonus} {
+		add(t)
+	}
+	return weights
+}
+
+// tuningEntry is a position reduced to what the tuner needs. The evaluation
+// is linear in every weight, so it is kept as the current middlegame and
+// endgame sums and updated as weights change.
+type tuningEntry struct {
+	mg, eg int
+	phase  int
+	result float64
+}
+
+// coefficient says how much a weight counts in the evaluation of a position.
+// Grouped by weight, index is the position; grouped by position, the weight.
+type coefficient struct {
+	index int32
+	value int32
+}
+
+// Tune adjusts the evaluation weights so the static evaluation predicts the
+// results of the given positions as well as possible (Texel's method). Each
+// weight in turn is moved up or down by a step while that lowers the mean
+// squared error between the results and the winning probabilities of the
+// evaluations. The given parameters are not changed.
+func Tune(params *EvalParams, positions []TuningPosition, opts TuneOptions) TuneResult {
+	if opts.Step <= 0 {
+		opts.Step = 1
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = runtime.NumCPU()
+	}
+
+	tuned := *params
+	weights := tuned.tunables()
+	entries, coefficients := extractFeatures(&tuned, positions, opts.Concurrency)
+
+	k := fitK(entries)
+	current := tuningError(entries, k)
+	result := TuneResult{K: k, InitialError: current}
+
+	for pass := 1; pass <= opts.Passes; pass++ {
+		improved := false
+		for i, weight := range weights {
+			for _, step := range []int{opts.Step, -opts.Step} {
+				delta := errorDelta(entries, coefficients[i], weight.endgame, step, k)
+				if delta < 0 {
+					*weight.value += step
+					applyStep(entries, coefficients[i], weight.endgame, step)
+					current += delta
+					improved = true
+					break
+				}
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(pass, current)
+		}
+		if !improved {
+			break
+		}
+	}
+
+	result.Params = &tuned
+	result.Error = tuningError(entries, k)
+	return result
+}
+
+// extractFeatures evaluates every position and finds the coefficient of each
+// weight by changing it by one and evaluating again
+func extractFeatures(params *EvalParams, positions []TuningPosition, concurrency int) ([]tuningEntry, [][]coefficient) {
+	entries := make([]tuningEntry, len(positions))
+	perPosition := make([][]coefficient, len(positions))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every worker changes weights on its own copy
+			local := *params
+			bot := NewChessBot(0)
+			bot.SetEvalParams(&local)
+			weights := local.tunables()
+
+			for j := range jobs {
+				pos := positions[j].Pos
+				base := bot.evaluateTerms(pos, nil)
+				entries[j] = tuningEntry{mg: base.MG, eg: base.EG, phase: gamePhase(pos), result: positions[j].Result}
+
+				for i, weight := range weights {
+					*weight.value++
+					changed := bot.evaluateTerms(pos, nil)
+					*weight.value--
+					if c := changed.MG - base.MG + changed.EG - base.EG; c != 0 {
+						perPosition[j] = append(perPosition[j], coefficient{index: int32(i), value: int32(c)})
+					}
+				}
+			}
+		}()
+	}
+	for j := range positions {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Regroup the coefficients by weight
+	coefficients := make([][]coefficient, len(params.tunables()))
+	for j, list := range perPosition {
+		for _, c := range list {
+			coefficients[c.index] = append(coefficients[c.index], coefficient{index: int32(j), value: c.value})
+		}
+	}
+	return entries, coefficients
+}
+
+// fitK finds the scaling constant that minimizes the error of the current
+// evaluation, by ternary search
+func fitK(entries []tuningEntry) float64 {
+	low, high := 0.0, 10.0
+	for i := 0; i < 100; i++ {
+		a := low + (high-low)/3
+		b := high - (high-low)/3
+		if tuningError(entries, a) < tuningError(entries, b) {
+			high = b
+		} else {
+			low = a
+		}
+	}
+	return (low + high) / 2
+}
+
+func sigmoid(score, k float64) float64 {
+	return 1 / (1 + math.Pow(10, -k*score/400))
+}
+
+func (e tuningEntry) score() float64 {
+	return float64(e.mg*e.phase+e.eg*(maxPhase-e.phase)) / maxPhase
+}
+
+func (e tuningEntry) squaredError(k float64) float64 {
+	diff := e.result - sigmoid(e.score(), k)
+	return diff * diff
+}
+
+// tuningError is the mean squared error of the predicted results
+func tuningError(entries []tuningEntry, k float64) float64 {
+	total := 0.0
+	for _, e := range entries {
+		total += e.squaredError(k)
+	}
+	return total / float64(len(entries))
+}
+
+// errorDelta is the change of the mean squared error if a weight was moved by
+// step. Only the positions the weight appears in are looked at.
+func errorDelta(entries []tuningEntry, coefficients []coefficient, endgame bool, step int, k float64) float64 {
+	delta := 0.0
+	for _, c := range coefficients {
+		e := entries[c.index]
+		before := e.squaredError(k)
+		if endgame {
+			e.eg += int(c.value) * step
+		} else {
+			e.mg += int(c.value) * step
+		}
+		delta += e.squaredError(k) - before
+	}
+	return delta / float64(len(entries))
+}
+
+// applyStep updates the sums of the positions a moved weight appears in
+func applyStep(entries []tuningEntry, coefficients []coefficient, endgame bool, step int) {
+	for _, c := range coefficients {
+		if endgame {
+			entries[c.index].eg += int(c.value) * step
+		} else {
+			entries[c.index].mg += int(c.value) * step
+		}
+	}
+}
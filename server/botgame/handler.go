@@ -0,0 +1,231 @@
+package botgame
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/TLeTu/Chess-Media/server/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateGameRequest is the body of a request to start a game against the bot
+type CreateGameRequest struct {
+	Color       string `json:"color"`       // "white", "black" or "random"; defaults to white
+	Level       int    `json:"level"`       // Optional, defaults to bot.DefaultLevel
+	Personality string `json:"personality"` // Optional, defaults to bot.DefaultPersonality
+}
+
+// MoveRequest is the body of a move in a game against the bot
+type MoveRequest struct {
+	Move string `json:"move"` // Coordinate notation ("e7e8q") or SAN
+}
+
+// MoveResponse is the game after the user's move and the bot's reply
+type MoveResponse struct {
+	State
+	BotMove string `json:"botMove,omitempty"`
+}
+
+// CreateGameHandler starts a game against the bot
+func CreateGameHandler(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var req CreateGameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var color engine.Color
+	switch req.Color {
+	case "", "white":
+		color = engine.White
+	case "black":
+		color = engine.Black
+	case "random":
+		color = engine.White
+		if rand.Intn(2) == 0 {
+			color = engine.Black
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid color selection"})
+		return
+	}
+
+	session, err := NewSession(user.ID, color, req.Level, req.Personality)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, session.State())
+}
+
+// GetGameHandler returns the current state of a game against the bot
+func GetGameHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, session.State())
+}
+
+// MoveHandler plays the user's move and returns the bot's reply
+func MoveHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+	var req MoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	botMove, err := session.Move(req.Move)
+	switch {
+	case errors.Is(err, ErrGameOver) || errors.Is(err, ErrNotYourTurn):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := MoveResponse{State: session.State()}
+	if botMove != nil {
+		response.BotMove = botMove.String()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ResignHandler resigns a game against the bot
+func ResignHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+	if err := session.Resign(); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, session.State())
+}
+
+// HintHandler suggests a move. The position is that of the bot game given
+// by the "game" parameter, or else the "fen" parameter. Any hint a user asks
+// for while playing a bot game is counted on that game.
+func HintHandler(c *gin.Context) {
+	if c.Query("game") != "" {
+		session, ok := sessionFromQuery(c)
+		if !ok {
+			return
+		}
+		hint, err := session.Hint()
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, hint)
+		return
+	}
+
+	pos, ok := positionFromQuery(c)
+	if !ok {
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	getHint := bot.GetHint
+	if session, ok := UserSession(user.ID); ok {
+		getHint = session.HintFor
+	}
+	hint, err := getHint(pos)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hint)
+}
+
+// ThreatsHandler shows what the opponent threatens in the position of the bot
+// game given by the "game" parameter, or else the "fen" parameter
+func ThreatsHandler(c *gin.Context) {
+	var pos *engine.Position
+	if c.Query("game") != "" {
+		session, ok := sessionFromQuery(c)
+		if !ok {
+			return
+		}
+		pos = session.Position()
+	} else {
+		var ok bool
+		if pos, ok = positionFromQuery(c); !ok {
+			return
+		}
+	}
+
+	threats, err := bot.GetThreats(pos)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, threats)
+}
+
+// positionFromQuery parses the "fen" parameter. It writes the error response
+// when it fails.
+func positionFromQuery(c *gin.Context) (*engine.Position, bool) {
+	fen := c.Query("fen")
+	if fen == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fen or game is required"})
+		return nil, false
+	}
+	pos, err := engine.ParseFEN(fen)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid FEN: %v", err)})
+		return nil, false
+	}
+	return pos, true
+}
+
+// sessionFromQuery looks up the session given by the "game" parameter
+func sessionFromQuery(c *gin.Context) (*Session, bool) {
+	return lookupSession(c, c.Query("game"))
+}
+
+// sessionFromRequest looks up the session in the URL, which must belong to the
+// authenticated user. It writes the error response when it fails.
+func sessionFromRequest(c *gin.Context) (*Session, bool) {
+	return lookupSession(c, c.Param("id"))
+}
+
+func lookupSession(c *gin.Context, id string) (*Session, bool) {
+	user, ok := currentUser(c)
+	if !ok {
+		return nil, false
+	}
+	session, ok := GetSession(id, user.ID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return nil, false
+	}
+	return session, true
+}
+
+func currentUser(c *gin.Context) (models.User, bool) {
+	value, exists := c.Get("user")
+	user, ok := value.(models.User)
+	if !exists || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return models.User{}, false
+	}
+	return user, true
+}
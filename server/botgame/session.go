@@ -0,0 +1,352 @@
+package botgame
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/database"
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/TLeTu/Chess-Media/server/models"
+	"github.com/TLeTu/Chess-Media/server/review"
+)
+
+// Sessions nobody has moved in for this long are dropped without being saved
+const sessionTTL = time.Hour
+
+// Errors returned by session operations
+var (
+	ErrGameOver    = errors.New("the game is over")
+	ErrNotYourTurn = errors.New("it's not your turn")
+)
+
+// Session is a game against the bot. The server holds the only copy of the
+// position, so clients can do nothing but submit moves for their own side.
+type Session struct {
+	mu sync.Mutex
+
+	ID          string
+	UserID      uint
+	UserColor   engine.Color
+	Level       int
+	Personality string
+
+	bot    *bot.ChessBot
+	start  *engine.Position
+	pos    *engine.Position
+	moves  []engine.Move
+	seen   map[uint64]int // Occurrences of each position, for threefold repetition
+	result string         // Empty while the game is in progress
+	reason string
+	gameID uint // ID of the saved game once finished
+	hints  int
+
+	// Unix nanoseconds of the last move. pruneSessions reads it without s.mu,
+	// which is held for the whole of a bot search.
+	updatedAt atomic.Int64
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*Session)
+	// Each user has at most one session; starting a new game replaces it
+	userSessions = make(map[uint]string)
+)
+
+// NewSession starts a game for a user against the bot at the given level and
+// personality. If the user plays Black the bot makes its first move at once.
+func NewSession(userID uint, color engine.Color, level int, personality string) (*Session, error) {
+	if level == 0 {
+		level = bot.DefaultLevel
+	}
+	if personality == "" {
+		personality = bot.DefaultPersonality
+	}
+	chessBot, err := bot.GetBot(level, personality)
+	if err != nil {
+		return nil, err
+	}
+
+	start := engine.NewGame()
+	s := &Session{
+		ID:          generateSessionID(),
+		UserID:      userID,
+		UserColor:   color,
+		Level:       level,
+		Personality: personality,
+		bot:         chessBot,
+		start:       start,
+		pos:         start,
+		seen:        map[uint64]int{start.Hash(): 1},
+	}
+	s.updatedAt.Store(time.Now().UnixNano())
+
+	sessionsMu.Lock()
+	pruneSessions()
+	if old, ok := userSessions[userID]; ok {
+		delete(sessions, old)
+	}
+	sessions[s.ID] = s
+	userSessions[userID] = s.ID
+	sessionsMu.Unlock()
+
+	if color != engine.White {
+		s.mu.Lock()
+		s.botMove()
+		s.mu.Unlock()
+	}
+	return s, nil
+}
+
+// GetSession returns a session of the given user
+func GetSession(id string, userID uint) (*Session, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok := sessions[id]
+	if !ok || s.UserID != userID {
+		return nil, false
+	}
+	return s, true
+}
+
+// UserSession returns the session the user is playing, if any
+func UserSession(userID uint) (*Session, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	id, ok := userSessions[userID]
+	if !ok {
+		return nil, false
+	}
+	s, ok := sessions[id]
+	return s, ok
+}
+
+// pruneSessions drops expired sessions. The caller holds sessionsMu.
+func pruneSessions() {
+	for id, s := range sessions {
+		if time.Since(time.Unix(0, s.updatedAt.Load())) > sessionTTL {
+			delete(sessions, id)
+			if userSessions[s.UserID] == id {
+				delete(userSessions, s.UserID)
+			}
+		}
+	}
+}
+
+// Move plays the user's move, in coordinate notation or SAN, and the bot's
+// reply. The returned move is the bot's reply, if the game went on.
+func (s *Session) Move(moveStr string) (*engine.Move, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.result != "" {
+		return nil, ErrGameOver
+	}
+	if s.pos.Turn != s.UserColor {
+		return nil, ErrNotYourTurn
+	}
+	move, err := engine.ParseMoveAny(s.pos, moveStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid move: %w", err)
+	}
+	s.play(move)
+	if s.result != "" {
+		return nil, nil
+	}
+	return s.botMove(), nil
+}
+
+// Position returns the current position of the game
+func (s *Session) Position() *engine.Position {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos
+}
+
+// HintFor suggests a move in any position. While the game is in progress the
+// hint is counted on it, so that hints asked for by FEN are not free.
+func (s *Session) HintFor(pos *engine.Position) (*bot.Hint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hint, err := bot.GetHint(pos)
+	if err != nil {
+		return nil, err
+	}
+	if s.result == "" {
+		s.hints++
+	}
+	return hint, nil
+}
+
+// Hint suggests a move to the user and counts the hint on the game
+func (s *Session) Hint() (*bot.Hint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.result != "" {
+		return nil, ErrGameOver
+	}
+	if s.pos.Turn != s.UserColor {
+		return nil, ErrNotYourTurn
+	}
+	hint, err := bot.GetHint(s.pos)
+	if err != nil {
+		return nil, err
+	}
+	s.hints++
+	return hint, nil
+}
+
+// Resign ends the game as a loss for the user
+func (s *Session) Resign() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.result != "" {
+		return ErrGameOver
+	}
+	result := models.ResultWhiteWins
+	if s.UserColor == engine.White {
+		result = models.ResultBlackWins
+	}
+	s.finish(result, "resignation")
+	return nil
+}
+
+// State is a snapshot of a session as sent to the client
+type State struct {
+	ID          string   `json:"id"`
+	FEN         string   `json:"fen"`
+	PlayerColor string   `json:"playerColor"`
+	Level       int      `json:"level"`
+	Personality string   `json:"personality"`
+	Moves       []string `json:"moves"` // Coordinate notation
+	GameStatus  string   `json:"gameStatus"`
+	Result      string   `json:"result,omitempty"`
+	Termination string   `json:"termination,omitempty"`
+	GameID      uint     `json:"gameId,omitempty"` // Saved game, once finished
+	Hints       int      `json:"hints"`
+}
+
+// State returns a snapshot of the session
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	moves := make([]string, len(s.moves))
+	for i, move := range s.moves {
+		moves[i] = move.String()
+	}
+	state := State{
+		ID:          s.ID,
+		FEN:         s.pos.String(),
+		PlayerColor: "white",
+		Level:       s.Level,
+		Personality: s.Personality,
+		Moves:       moves,
+		GameStatus:  s.pos.GetGameStatus().String(),
+		Result:      s.result,
+		Termination: s.reason,
+		GameID:      s.gameID,
+		Hints:       s.hints,
+	}
+	if s.UserColor == engine.Black {
+		state.PlayerColor = "black"
+	}
+	if s.result != "" {
+		// Resignations and repetitions are not visible on the board
+		state.GameStatus = s.reason
+	}
+	return state
+}
+
+// botMove lets the bot play in the current position. The caller holds s.mu.
+func (s *Session) botMove() *engine.Move {
+	move := s.bot.BestMove(s.pos)
+	if move.From == move.To {
+		return nil
+	}
+	s.play(move)
+	return &move
+}
+
+// play applies a move and ends the game when it is over. The caller holds s.mu.
+func (s *Session) play(move engine.Move) {
+	s.pos = engine.ApplyMove(s.pos, move)
+	s.moves = append(s.moves, move)
+	s.updatedAt.Store(time.Now().UnixNano())
+
+	hash := s.pos.Hash()
+	s.seen[hash]++
+
+	status := s.pos.GetGameStatus()
+	if status == engine.InProgress && s.seen[hash] >= 3 {
+		status = engine.DrawByRepetition
+	}
+	switch status {
+	case engine.InProgress:
+		return
+	case engine.Checkmate:
+		result := models.ResultWhiteWins
+		if s.pos.Turn == engine.White {
+			result = models.ResultBlackWins
+		}
+		s.finish(result, status.String())
+	default:
+		s.finish(models.ResultDraw, status.String())
+	}
+}
+
+// finish records the result and saves the game to the user's history. The
+// caller holds s.mu.
+func (s *Session) finish(result string, reason string) {
+	s.result = result
+	s.reason = reason
+
+	moves := make([]string, len(s.moves))
+	for i, move := range s.moves {
+		moves[i] = move.String()
+	}
+	game := &models.Game{
+		StartFEN:       s.start.String(),
+		Moves:          strings.Join(moves, " "),
+		Result:         result,
+		Termination:    reason,
+		BotLevel:       s.Level,
+		BotPersonality: s.Personality,
+		Hints:          s.hints,
+	}
+	if s.UserColor == engine.White {
+		game.WhiteID = s.UserID
+	} else {
+		game.BlackID = s.UserID
+	}
+
+	if err := database.SaveGame(game); err != nil {
+		log.Printf("Error saving bot game %s: %v", s.ID, err)
+		return
+	}
+	s.gameID = game.ID
+	log.Printf("Bot game %s saved with ID %d", s.ID, game.ID)
+
+	if len(s.moves) > 0 {
+		review.Enqueue(game.ID)
+	}
+}
+
+func generateSessionID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,154 @@
+// Command calibrate plays bot levels against each other and estimates their
+// relative Elo ratings.
+//
+// Each level plays the next stronger level over a number of games with
+// alternating colors. The Elo difference of every pair is chained starting
+// from the weakest level, which is anchored at a fixed rating.
+//
+//	go run ./cmd/calibrate -games 40 -anchor 400
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// openings are short, balanced starting positions used to vary the games
+var openings = []string{
+	"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+	"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2",
+	"rnbqkbnr/pp1ppppp/8/2p5/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2",
+	"rnbqkbnr/ppp1pppp/8/3p4/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2",
+	"rnbqkb1r/pppppppp/5n2/8/2P5/8/PP1PPPPP/RNBQKBNR w KQkq - 1 2",
+	"rnbqkbnr/pppp1ppp/4p3/8/3PP3/8/PPP2PPP/RNBQKBNR b KQkq - 0 2",
+}
+
+type pairResult struct {
+	wins, draws, losses int
+}
+
+func (r pairResult) score() float64 {
+	games := r.wins + r.draws + r.losses
+	if games == 0 {
+		return 0.5
+	}
+	return (float64(r.wins) + 0.5*float64(r.draws)) / float64(games)
+}
+
+func main() {
+	levelsFlag := flag.String("levels", "", "comma separated level IDs to calibrate (default all)")
+	games := flag.Int("games", 20, "games per pair of levels")
+	maxPlies := flag.Int("maxplies", 300, "half-moves before a game is adjudicated as a draw")
+	anchor := flag.Int("anchor", 400, "Elo assigned to the weakest level")
+	concurrency := flag.Int("concurrency", runtime.NumCPU(), "games played in parallel")
+	verbose := flag.Bool("v", false, "log every move chosen by the bots")
+	flag.Parse()
+
+	levels, err := parseLevels(*levelsFlag)
+	if err != nil {
+		log.Fatalf("Invalid levels: %v", err)
+	}
+	if len(levels) < 2 {
+		log.Fatal("At least two levels are required")
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	elo := float64(*anchor)
+	fmt.Printf("%-3s %-14s %8s\n", "ID", "Level", "Elo")
+	fmt.Printf("%-3d %-14s %8.0f\n", levels[0].ID, levels[0].Name, elo)
+
+	for i := 0; i+1 < len(levels); i++ {
+		weaker, stronger := levels[i], levels[i+1]
+		result := playPair(stronger, weaker, *games, *maxPlies, *concurrency)
+		diff := bot.EloDifference(result.score())
+		elo += diff
+
+		fmt.Printf("    %s vs %s: +%d =%d -%d (score %.3f, %+.0f Elo)\n",
+			stronger.Name, weaker.Name, result.wins, result.draws, result.losses, result.score(), diff)
+		fmt.Printf("%-3d %-14s %8.0f\n", stronger.ID, stronger.Name, elo)
+	}
+}
+
+// playPair plays games between two levels and returns the result from the
+// first level's point of view
+func playPair(first, second bot.Level, games, maxPlies, concurrency int) pairResult {
+	firstBot := bot.NewChessBotForLevel(first)
+	secondBot := bot.NewChessBotForLevel(second)
+
+	var mu sync.Mutex
+	var result pairResult
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for game := 0; game < games; game++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(game int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start, err := engine.ParseFEN(openings[(game/2)%len(openings)])
+			if err != nil {
+				log.Fatalf("Invalid opening: %v", err)
+			}
+
+			// Alternate colors so both levels play each opening with both sides
+			firstIsWhite := game%2 == 0
+			white, black := firstBot, secondBot
+			if !firstIsWhite {
+				white, black = secondBot, firstBot
+			}
+
+			record := bot.PlayGame(white, black, start, maxPlies)
+			score := bot.ResultScore(record.Result)
+			if !firstIsWhite {
+				score = 1 - score
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch score {
+			case 1:
+				result.wins++
+			case 0:
+				result.losses++
+			default:
+				result.draws++
+			}
+		}(game)
+	}
+	wg.Wait()
+	return result
+}
+
+// parseLevels resolves a comma separated list of level IDs
+func parseLevels(s string) ([]bot.Level, error) {
+	if s == "" {
+		return bot.Levels, nil
+	}
+
+	var levels []bot.Level
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		level, ok := bot.GetLevel(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown level %d", id)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// timeControl is base time plus increment per move. The zero value means
+// players search with their own depth and move time limits.
+type timeControl struct {
+	base      time.Duration
+	increment time.Duration
+}
+
+func (tc timeControl) enabled() bool {
+	return tc.base > 0
+}
+
+// String formats the time control as in the PGN TimeControl tag
+func (tc timeControl) String() string {
+	if !tc.enabled() {
+		return "-"
+	}
+	return fmt.Sprintf("%g+%g", tc.base.Seconds(), tc.increment.Seconds())
+}
+
+// adjudication ends games early once their outcome is clear
+type adjudication struct {
+	maxPlies int
+
+	// A game is drawn once both sides scored within drawScore for drawMoveCount
+	// moves in a row, from move drawMoveNumber on
+	drawMoveNumber int
+	drawMoveCount  int
+	drawScore      int
+
+	// A game is lost by a side once both sides agreed it is behind by
+	// resignScore for resignMoveCount moves in a row
+	resignMoveCount int
+	resignScore     int
+}
+
+// gameResult is a finished game of the match
+type gameResult struct {
+	start  *engine.Position
+	moves  []engine.Move
+	result string // bot.WhiteWins, bot.BlackWins or bot.Draw
+	reason string
+}
+
+// playGame plays one game between two players from a starting position
+func playGame(white, black player, start *engine.Position, tc timeControl, adj adjudication) (gameResult, error) {
+	game := gameResult{start: start}
+	pos := start
+	seen := map[uint64]int{pos.Hash(): 1}
+	clocks := [2]time.Duration{tc.base, tc.base}
+
+	// Consecutive plies with a drawish score and with a winning score for White
+	drawPlies, whiteWinPlies, blackWinPlies := 0, 0, 0
+
+	for ply := 0; ; ply++ {
+		switch status := pos.GetGameStatus(); {
+		case status == engine.Checkmate && pos.Turn == engine.White:
+			return game.finish(bot.BlackWins, "checkmate"), nil
+		case status == engine.Checkmate:
+			return game.finish(bot.WhiteWins, "checkmate"), nil
+		case status != engine.InProgress:
+			return game.finish(bot.Draw, status.String()), nil
+		case ply >= adj.maxPlies:
+			return game.finish(bot.Draw, "max_plies"), nil
+		}
+
+		side := colorIndex(pos.Turn)
+		mover := white
+		if pos.Turn == engine.Black {
+			mover = black
+		}
+
+		limits := searchLimits{
+			timed:          tc.enabled(),
+			white:          clocks[0],
+			black:          clocks[1],
+			whiteInc:       tc.increment,
+			blackInc:       tc.increment,
+			sideToMoveTime: clocks[side],
+			sideToMoveInc:  tc.increment,
+		}
+		started := time.Now()
+		chosen, err := mover.move(start, game.moves, pos, limits)
+		if err != nil {
+			return game, err
+		}
+		if tc.enabled() {
+			clocks[side] -= time.Since(started)
+			if clocks[side] <= 0 {
+				if pos.Turn == engine.White {
+					return game.finish(bot.BlackWins, "time_forfeit"), nil
+				}
+				return game.finish(bot.WhiteWins, "time_forfeit"), nil
+			}
+			clocks[side] += tc.increment
+		}
+
+		pos = engine.ApplyMove(pos, chosen.move)
+		game.moves = append(game.moves, chosen.move)
+
+		seen[pos.Hash()]++
+		if seen[pos.Hash()] >= 3 {
+			return game.finish(bot.Draw, engine.DrawByRepetition.String()), nil
+		}
+
+		// Scores are turned to White's point of view. A move without a score
+		// breaks every streak, so bots playing imperfect moves are never
+		// adjudicated.
+		if !chosen.hasScore {
+			drawPlies, whiteWinPlies, blackWinPlies = 0, 0, 0
+			continue
+		}
+		score := chosen.score
+		if side == 1 {
+			score = -score
+		}
+
+		moveNumber := ply/2 + 1
+		if adj.drawMoveCount > 0 && moveNumber >= adj.drawMoveNumber && abs(score) <= adj.drawScore {
+			drawPlies++
+		} else {
+			drawPlies = 0
+		}
+		if adj.drawMoveCount > 0 && drawPlies >= 2*adj.drawMoveCount {
+			return game.finish(bot.Draw, "adjudication"), nil
+		}
+
+		if adj.resignMoveCount > 0 {
+			whiteWinPlies = streak(whiteWinPlies, score >= adj.resignScore)
+			blackWinPlies = streak(blackWinPlies, score <= -adj.resignScore)
+			switch {
+			case whiteWinPlies >= 2*adj.resignMoveCount:
+				return game.finish(bot.WhiteWins, "adjudication"), nil
+			case blackWinPlies >= 2*adj.resignMoveCount:
+				return game.finish(bot.BlackWins, "adjudication"), nil
+			}
+		}
+	}
+}
+
+func (g gameResult) finish(result, reason string) gameResult {
+	g.result = result
+	g.reason = reason
+	return g
+}
+
+func streak(count int, holds bool) int {
+	if holds {
+		return count + 1
+	}
+	return 0
+}
+
+func colorIndex(c engine.Color) int {
+	if c == engine.White {
+		return 0
+	}
+	return 1
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,300 @@
+// Command match plays a match between two players and estimates their Elo
+// difference.
+//
+// A player is a bot configuration or an external UCI engine, given as a comma
+// separated list of key=value pairs:
+//
+//	level=8,personality=attacker,params=tuned.json,depth=5,movetime=500,threads=2,name=Tuned
+//	uci=/usr/bin/stockfish,option.Skill Level=3,movetime=100
+//
+// Every opening of the suite is played twice with colors swapped. A match can
+// be stopped early by a sequential probability ratio test, and all games can
+// be written to a PGN file.
+//
+//	go run ./cmd/match -a params=tuned.json -b level=8 -games 400 -tc 10+0.1 -sprt elo0=0,elo1=10 -pgn match.pgn
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+func main() {
+	aSpec := flag.String("a", "", "first player, e.g. level=8,personality=attacker")
+	bSpec := flag.String("b", "", "second player, e.g. uci=/usr/bin/stockfish,movetime=100")
+	games := flag.Int("games", 100, "maximum number of games")
+	concurrency := flag.Int("concurrency", runtime.NumCPU(), "games played in parallel")
+	tcFlag := flag.String("tc", "", "time control as base+increment in seconds, e.g. 10+0.1 (default the players' own limits)")
+	openingsPath := flag.String("openings", "", "opening suite as FEN/EPD lines or PGN (default built-in positions)")
+	pgnPath := flag.String("pgn", "", "file all games are written to")
+	sprtFlag := flag.String("sprt", "", "stop once a test decides, e.g. elo0=0,elo1=5,alpha=0.05,beta=0.05")
+	maxPlies := flag.Int("maxplies", 300, "half-moves before a game is adjudicated as a draw")
+	drawMoveNumber := flag.Int("draw-movenumber", 40, "first move at which draws are adjudicated")
+	drawMoveCount := flag.Int("draw-movecount", 8, "moves in a row both players must score near zero for a draw (0 disables)")
+	drawScore := flag.Int("draw-score", 10, "largest score in centipawns counted as near zero")
+	resignMoveCount := flag.Int("resign-movecount", 3, "moves in a row both players must agree one side is lost (0 disables)")
+	resignScore := flag.Int("resign-score", 600, "score in centipawns at which a side is lost")
+	verbose := flag.Bool("v", false, "log every move chosen by the bots")
+	flag.Parse()
+
+	if *aSpec == "" || *bSpec == "" {
+		fmt.Fprintln(os.Stderr, "usage: match -a player -b player [flags]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	a, err := parsePlayerConfig(*aSpec)
+	if err != nil {
+		fatalf("Invalid player -a: %v", err)
+	}
+	b, err := parsePlayerConfig(*bSpec)
+	if err != nil {
+		fatalf("Invalid player -b: %v", err)
+	}
+	if a.name == b.name {
+		a.name += " (a)"
+		b.name += " (b)"
+	}
+	tc, err := parseTimeControl(*tcFlag)
+	if err != nil {
+		fatalf("Invalid time control: %v", err)
+	}
+	var test *sprt
+	if *sprtFlag != "" {
+		if test, err = parseSPRT(*sprtFlag); err != nil {
+			fatalf("Invalid SPRT: %v", err)
+		}
+	}
+	openings, err := loadOpenings(*openingsPath)
+	if err != nil {
+		fatalf("Failed to load openings: %v", err)
+	}
+	if len(openings) == 0 {
+		fatalf("The opening suite is empty")
+	}
+
+	var pgn io.Writer
+	if *pgnPath != "" {
+		file, err := os.Create(*pgnPath)
+		if err != nil {
+			fatalf("Failed to create PGN file: %v", err)
+		}
+		defer file.Close()
+		pgn = file
+	}
+
+	m := &match{
+		a:    a,
+		b:    b,
+		tc:   tc,
+		test: test,
+		pgn:  pgn,
+		adj: adjudication{
+			maxPlies:        *maxPlies,
+			drawMoveNumber:  *drawMoveNumber,
+			drawMoveCount:   *drawMoveCount,
+			drawScore:       *drawScore,
+			resignMoveCount: *resignMoveCount,
+			resignScore:     *resignScore,
+		},
+		total: *games,
+	}
+	m.run(openings, max(1, *concurrency))
+	m.report()
+}
+
+// match holds the state of a running match
+type match struct {
+	a, b  *playerConfig
+	tc    timeControl
+	adj   adjudication
+	test  *sprt
+	pgn   io.Writer
+	total int
+
+	mu       sync.Mutex
+	score    score // From a's point of view
+	played   int
+	failed   int
+	decision string
+}
+
+// run plays the games, stopping early once the SPRT decides
+func (m *match) run(openings []*engine.Position, concurrency int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for game := 0; game < m.total; game++ {
+		sem <- struct{}{}
+		m.mu.Lock()
+		decided := m.decision != ""
+		m.mu.Unlock()
+		if decided {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(game int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Each opening is played twice, once with each player as White
+			start := openings[(game/2)%len(openings)]
+			aIsWhite := game%2 == 0
+			result, err := m.play(start, aIsWhite)
+			m.record(game, result, aIsWhite, err)
+		}(game)
+	}
+	wg.Wait()
+}
+
+// play plays one game with fresh players
+func (m *match) play(start *engine.Position, aIsWhite bool) (gameResult, error) {
+	whiteConfig, blackConfig := m.a, m.b
+	if !aIsWhite {
+		whiteConfig, blackConfig = m.b, m.a
+	}
+	white, err := whiteConfig.newPlayer()
+	if err != nil {
+		return gameResult{}, err
+	}
+	defer white.close()
+	black, err := blackConfig.newPlayer()
+	if err != nil {
+		return gameResult{}, err
+	}
+	defer black.close()
+
+	return playGame(white, black, start, m.tc, m.adj)
+}
+
+// record adds a finished game to the score, prints it and writes it to the
+// PGN file
+func (m *match) record(game int, result gameResult, aIsWhite bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	white, black := m.a.name, m.b.name
+	if !aIsWhite {
+		white, black = black, white
+	}
+	if err != nil {
+		m.failed++
+		fmt.Fprintf(os.Stderr, "Game %d (%s vs %s) failed: %v\n", game+1, white, black, err)
+		return
+	}
+
+	points := bot.ResultScore(result.result)
+	if !aIsWhite {
+		points = 1 - points
+	}
+	switch points {
+	case 1:
+		m.score.wins++
+	case 0:
+		m.score.losses++
+	default:
+		m.score.draws++
+	}
+	m.played++
+
+	fmt.Printf("Game %d: %s vs %s %s (%s) | %s: +%d =%d -%d\n",
+		game+1, white, black, result.result, result.reason,
+		m.a.name, m.score.wins, m.score.draws, m.score.losses)
+
+	if m.pgn != nil {
+		err := engine.WritePGN(m.pgn, &engine.PGNGame{
+			Tags: map[string]string{
+				"Event":       fmt.Sprintf("%s vs %s", m.a.name, m.b.name),
+				"Site":        "match",
+				"Date":        time.Now().Format("2006.01.02"),
+				"Round":       strconv.Itoa(game + 1),
+				"White":       white,
+				"Black":       black,
+				"Termination": result.reason,
+				"TimeControl": m.tc.String(),
+				"PlyCount":    strconv.Itoa(len(result.moves)),
+			},
+			Start:  result.start,
+			Moves:  result.moves,
+			Result: result.result,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write game %d: %v\n", game+1, err)
+		}
+	}
+
+	if m.test != nil && m.decision == "" {
+		m.decision = m.test.decision(m.score)
+	}
+}
+
+// report prints the final score, the Elo estimate and the SPRT state
+func (m *match) report() {
+	elo, margin := m.score.elo()
+	fmt.Println()
+	fmt.Printf("Score of %s vs %s: +%d =%d -%d [%.3f] %d games\n",
+		m.a.name, m.b.name, m.score.wins, m.score.draws, m.score.losses, m.score.mean(), m.played)
+	fmt.Printf("Elo difference: %+.1f +/- %.1f, LOS: %.1f%%\n", elo, margin, m.score.los()*100)
+	if m.failed > 0 {
+		fmt.Printf("%d games failed\n", m.failed)
+	}
+
+	if m.test != nil {
+		lower, upper := m.test.bounds()
+		state := "no decision"
+		switch m.decision {
+		case "H0":
+			state = fmt.Sprintf("H0 accepted: %s is not %g Elo stronger", m.a.name, m.test.elo1)
+		case "H1":
+			state = fmt.Sprintf("H1 accepted: %s is more than %g Elo stronger", m.a.name, m.test.elo0)
+		}
+		fmt.Printf("SPRT [%g, %g]: LLR %.2f (%.2f, %.2f) %s\n",
+			m.test.elo0, m.test.elo1, m.test.llr(m.score), lower, upper, state)
+	}
+}
+
+// parseTimeControl parses "base+increment" in seconds. An empty string means
+// no time control.
+func parseTimeControl(s string) (timeControl, error) {
+	if s == "" {
+		return timeControl{}, nil
+	}
+	baseStr, incStr, _ := strings.Cut(s, "+")
+	base, err := strconv.ParseFloat(baseStr, 64)
+	if err != nil || base <= 0 {
+		return timeControl{}, fmt.Errorf("invalid base time %q", baseStr)
+	}
+	increment := 0.0
+	if incStr != "" {
+		if increment, err = strconv.ParseFloat(incStr, 64); err != nil || increment < 0 {
+			return timeControl{}, fmt.Errorf("invalid increment %q", incStr)
+		}
+	}
+	return timeControl{
+		base:      time.Duration(base * float64(time.Second)),
+		increment: time.Duration(increment * float64(time.Second)),
+	}, nil
+}
+
+// fatalf reports an error and exits. log is silenced unless -v is given, so
+// errors are written to standard error directly.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// defaultOpenings are short, balanced starting positions used when no opening
+// suite is given
+var defaultOpenings = []string{
+	"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+	"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2",
+	"rnbqkbnr/pp1ppppp/8/2p5/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2",
+	"rnbqkbnr/ppp1pppp/8/3p4/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2",
+	"rnbqkb1r/pppppppp/5n2/8/2P5/8/PP1PPPPP/RNBQKBNR w KQkq - 1 2",
+	"rnbqkbnr/pppp1ppp/4p3/8/3PP3/8/PPP2PPP/RNBQKBNR b KQkq - 0 2",
+}
+
+// loadOpenings reads an opening suite. PGN files give the position at the end
+// of every game; other files hold one FEN or EPD position per line.
+func loadOpenings(path string) ([]*engine.Position, error) {
+	if path == "" {
+		openings := make([]*engine.Position, len(defaultOpenings))
+		for i, fen := range defaultOpenings {
+			openings[i], _ = engine.ParseFEN(fen)
+		}
+		return openings, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var openings []*engine.Position
+	if strings.EqualFold(filepath.Ext(path), ".pgn") {
+		games, err := engine.ReadPGN(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, game := range games {
+			pos := game.Start
+			for _, move := range game.Moves {
+				pos = engine.ApplyMove(pos, move)
+			}
+			openings = append(openings, pos)
+		}
+		return openings, nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// EPD lines carry operations instead of the move counters
+		fen := strings.Join(fields[:4], " ") + " 0 1"
+		if len(fields) >= 6 && isNumber(fields[4]) && isNumber(fields[5]) {
+			fen = strings.Join(fields[:6], " ")
+		}
+		pos, err := engine.ParseFEN(fen)
+		if err != nil {
+			return nil, err
+		}
+		openings = append(openings, pos)
+	}
+	return openings, scanner.Err()
+}
+
+func isNumber(s string) bool {
+	return strings.Trim(s, "0123456789") == "" && s != ""
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Timed searches of bots are capped at this depth
+const maxTimedDepth = 32
+
+// Default time per move of UCI engines in games without a time control
+const defaultUCIMoveTime = time.Second
+
+// searchLimits is what a player may use for one move
+type searchLimits struct {
+	timed          bool
+	white, black   time.Duration // Remaining clock time
+	whiteInc       time.Duration
+	blackInc       time.Duration
+	sideToMoveTime time.Duration
+	sideToMoveInc  time.Duration
+}
+
+// moveResult is a move chosen by a player
+type moveResult struct {
+	move     engine.Move
+	score    int  // Centipawns from the mover's point of view
+	hasScore bool // Bots playing imperfect moves report no score
+}
+
+// player plays the moves of one side of a game
+type player interface {
+	// move chooses a move in pos, which was reached by playing moves from start
+	move(start *engine.Position, moves []engine.Move, pos *engine.Position, limits searchLimits) (moveResult, error)
+	close()
+}
+
+// playerConfig describes a player given on the command line as a comma
+// separated list of key=value pairs, e.g. "level=8,personality=attacker" or
+// "uci=/usr/bin/stockfish,option.Skill Level=3"
+type playerConfig struct {
+	name        string
+	level       bot.Level
+	personality string
+	params      *bot.EvalParams
+	depth       int
+	moveTime    time.Duration
+	threads     int
+
+	uciPath    string
+	uciOptions map[string]string
+}
+
+func parsePlayerConfig(spec string) (*playerConfig, error) {
+	level, _ := bot.GetLevel(bot.DefaultLevel)
+	config := &playerConfig{level: level, threads: 1, uciOptions: make(map[string]string)}
+
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value: %q", part)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var err error
+		switch {
+		case key == "name":
+			config.name = value
+		case key == "level":
+			var id int
+			if id, err = strconv.Atoi(value); err == nil {
+				if config.level, ok = bot.GetLevel(id); !ok {
+					err = fmt.Errorf("unknown level %d", id)
+				}
+			}
+		case key == "personality":
+			if _, ok := bot.GetPersonality(value); !ok {
+				err = fmt.Errorf("unknown personality %q", value)
+			}
+			config.personality = value
+		case key == "params":
+			config.params, err = bot.LoadEvalParams(value)
+		case key == "depth":
+			config.depth, err = strconv.Atoi(value)
+		case key == "movetime":
+			var ms int
+			ms, err = strconv.Atoi(value)
+			config.moveTime = time.Duration(ms) * time.Millisecond
+		case key == "threads":
+			config.threads, err = strconv.Atoi(value)
+		case key == "uci":
+			config.uciPath = value
+		case strings.HasPrefix(key, "option."):
+			config.uciOptions[strings.TrimPrefix(key, "option.")] = value
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	if config.name == "" {
+		config.name = config.defaultName()
+	}
+	return config, nil
+}
+
+func (c *playerConfig) defaultName() string {
+	if c.uciPath != "" {
+		return strings.TrimSuffix(c.uciPath[strings.LastIndexAny(c.uciPath, "/\\")+1:], ".exe")
+	}
+	name := fmt.Sprintf("Bot %s", c.level.Name)
+	if c.personality != "" {
+		name += " " + c.personality
+	}
+	if c.params != nil {
+		name += " (custom)"
+	}
+	return name
+}
+
+// newPlayer starts a player for one game
+func (c *playerConfig) newPlayer() (player, error) {
+	if c.uciPath != "" {
+		return startUCIPlayer(c)
+	}
+
+	chessBot := bot.NewChessBotForLevel(c.level)
+	if c.personality != "" {
+		params, _ := bot.GetPersonality(c.personality)
+		chessBot.SetEvalParams(params)
+	}
+	if c.params != nil {
+		chessBot.SetEvalParams(c.params)
+	}
+	if c.depth > 0 {
+		chessBot.SetDepth(c.depth)
+	}
+	if c.moveTime > 0 {
+		chessBot.SetMoveTime(c.moveTime)
+	}
+	chessBot.SetThreads(c.threads)
+	return &botPlayer{config: c, bot: chessBot}, nil
+}
+
+// botPlayer plays with a bot of this repository
+type botPlayer struct {
+	config *playerConfig
+	bot    *bot.ChessBot
+}
+
+func (p *botPlayer) move(start *engine.Position, moves []engine.Move, pos *engine.Position, limits searchLimits) (moveResult, error) {
+	if limits.timed {
+		if p.config.depth == 0 {
+			p.bot.SetDepth(maxTimedDepth)
+		}
+		p.bot.SetMoveTime(timeBudget(limits.sideToMoveTime, limits.sideToMoveInc))
+	}
+
+	// Levels that play imperfect moves on purpose keep doing so
+	if p.config.level.EvalNoise > 0 || p.config.level.BlunderChance > 0 {
+		return moveResult{move: p.bot.BestMove(pos)}, nil
+	}
+	result := p.bot.Search(pos)
+	return moveResult{move: result.Move, score: result.Score, hasScore: true}, nil
+}
+
+func (p *botPlayer) close() {}
+
+// timeBudget splits the remaining clock time into the time for one move
+func timeBudget(remaining, increment time.Duration) time.Duration {
+	budget := remaining/25 + increment*3/4
+	budget = min(budget, remaining/2)
+	return max(budget, 10*time.Millisecond)
+}
+
+// uciPlayer plays with an external engine speaking the UCI protocol
+type uciPlayer struct {
+	config *playerConfig
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string
+}
+
+func startUCIPlayer(c *playerConfig) (*uciPlayer, error) {
+	cmd := exec.Command(c.uciPath)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", c.uciPath, err)
+	}
+
+	p := &uciPlayer{config: c, cmd: cmd, stdin: stdin, lines: make(chan string, 64)}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			p.lines <- scanner.Text()
+		}
+		close(p.lines)
+	}()
+
+	p.send("uci")
+	if _, err := p.waitFor("uciok", 10*time.Second); err != nil {
+		p.close()
+		return nil, err
+	}
+	for name, value := range c.uciOptions {
+		p.send(fmt.Sprintf("setoption name %s value %s", name, value))
+	}
+	p.send("ucinewgame")
+	p.send("isready")
+	if _, err := p.waitFor("readyok", 10*time.Second); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *uciPlayer) send(command string) {
+	fmt.Fprintln(p.stdin, command)
+}
+
+// waitFor reads lines until one starts with prefix and returns the lines read
+func (p *uciPlayer) waitFor(prefix string, timeout time.Duration) ([]string, error) {
+	var lines []string
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return lines, fmt.Errorf("%s exited", p.config.name)
+			}
+			lines = append(lines, line)
+			if strings.HasPrefix(line, prefix) {
+				return lines, nil
+			}
+		case <-timer.C:
+			return lines, fmt.Errorf("%s did not answer %q in time", p.config.name, prefix)
+		}
+	}
+}
+
+func (p *uciPlayer) move(start *engine.Position, moves []engine.Move, pos *engine.Position, limits searchLimits) (moveResult, error) {
+	command := "position fen " + start.String()
+	if len(moves) > 0 {
+		uci := make([]string, len(moves))
+		for i, move := range moves {
+			uci[i] = move.String()
+		}
+		command += " moves " + strings.Join(uci, " ")
+	}
+	p.send(command)
+
+	// The engine is given some slack beyond its clock before it is abandoned
+	timeout := 10 * time.Second
+	switch {
+	case limits.timed:
+		p.send(fmt.Sprintf("go wtime %d btime %d winc %d binc %d",
+			limits.white.Milliseconds(), limits.black.Milliseconds(),
+			limits.whiteInc.Milliseconds(), limits.blackInc.Milliseconds()))
+		timeout += limits.sideToMoveTime
+	case p.config.depth > 0:
+		p.send(fmt.Sprintf("go depth %d", p.config.depth))
+		timeout = 10 * time.Minute
+	default:
+		moveTime := p.config.moveTime
+		if moveTime == 0 {
+			moveTime = defaultUCIMoveTime
+		}
+		p.send(fmt.Sprintf("go movetime %d", moveTime.Milliseconds()))
+		timeout += moveTime
+	}
+
+	lines, err := p.waitFor("bestmove", timeout)
+	if err != nil {
+		return moveResult{}, err
+	}
+
+	result := moveResult{}
+	for _, line := range lines {
+		if score, ok := parseUCIScore(line); ok {
+			result.score, result.hasScore = score, true
+		}
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 2 {
+		return moveResult{}, fmt.Errorf("%s sent an invalid bestmove: %q", p.config.name, lines[len(lines)-1])
+	}
+	if result.move, err = engine.ParseMove(pos, fields[1]); err != nil {
+		return moveResult{}, fmt.Errorf("%s played an illegal move: %w", p.config.name, err)
+	}
+	return result, nil
+}
+
+// parseUCIScore reads the score of an info line. Mates are mapped to large
+// scores so adjudication treats them as decisive.
+func parseUCIScore(line string) (int, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "info" {
+		return 0, false
+	}
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] != "score" {
+			continue
+		}
+		value, err := strconv.Atoi(fields[i+2])
+		if err != nil {
+			return 0, false
+		}
+		switch fields[i+1] {
+		case "cp":
+			return value, true
+		case "mate":
+			if value > 0 {
+				return 100000 - value, true
+			}
+			return -100000 - value, true
+		}
+	}
+	return 0, false
+}
+
+func (p *uciPlayer) close() {
+	p.send("quit")
+	p.stdin.Close()
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-done
+	}
+	// Drain the output so the reader goroutine can finish
+	for range p.lines {
+	}
+}
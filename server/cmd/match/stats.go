@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// score is the result of the match from the first player's point of view
+type score struct {
+	wins, draws, losses int
+}
+
+func (s score) games() int {
+	return s.wins + s.draws + s.losses
+}
+
+// mean is the average points per game
+func (s score) mean() float64 {
+	if s.games() == 0 {
+		return 0.5
+	}
+	return (float64(s.wins) + 0.5*float64(s.draws)) / float64(s.games())
+}
+
+// variance is the variance of the points of a single game
+func (s score) variance() float64 {
+	n := float64(s.games())
+	if n == 0 {
+		return 0
+	}
+	mean := s.mean()
+	return (float64(s.wins)+0.25*float64(s.draws))/n - mean*mean
+}
+
+// elo estimates the Elo difference with the half width of its 95% confidence
+// interval
+func (s score) elo() (float64, float64) {
+	mean := s.mean()
+	if s.games() == 0 {
+		return 0, 0
+	}
+	margin := 1.959964 * math.Sqrt(s.variance()/float64(s.games()))
+	low, high := eloFromScore(mean-margin), eloFromScore(mean+margin)
+	return eloFromScore(mean), (high - low) / 2
+}
+
+// los is the likelihood of superiority, the probability that the first player
+// is the stronger one. Draws are ignored.
+func (s score) los() float64 {
+	decisive := float64(s.wins + s.losses)
+	if decisive == 0 {
+		return 0.5
+	}
+	return 0.5 * (1 + math.Erf(float64(s.wins-s.losses)/math.Sqrt(2*decisive)))
+}
+
+// eloFromScore converts an expected score into an Elo difference. Scores
+// outside (0, 1) are clamped so clean sweeps stay finite.
+func eloFromScore(s float64) float64 {
+	s = math.Max(0.001, math.Min(0.999, s))
+	return -400 * math.Log10(1/s-1)
+}
+
+func scoreFromElo(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// sprt is a sequential probability ratio test deciding between the
+// hypotheses that the first player is elo0 or elo1 stronger
+type sprt struct {
+	elo0, elo1  float64
+	alpha, beta float64
+}
+
+// parseSPRT parses "elo0=0,elo1=5,alpha=0.05,beta=0.05". Missing error
+// rates default to 0.05.
+func parseSPRT(spec string) (*sprt, error) {
+	test := &sprt{alpha: 0.05, beta: 0.05}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value: %q", part)
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		switch key {
+		case "elo0":
+			test.elo0 = number
+		case "elo1":
+			test.elo1 = number
+		case "alpha":
+			test.alpha = number
+		case "beta":
+			test.beta = number
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+		seen[key] = true
+	}
+
+	switch {
+	case !seen["elo0"] || !seen["elo1"]:
+		return nil, fmt.Errorf("elo0 and elo1 are required")
+	case test.elo1 <= test.elo0:
+		return nil, fmt.Errorf("elo1 must be greater than elo0")
+	case test.alpha <= 0 || test.alpha >= 1 || test.beta <= 0 || test.beta >= 1:
+		return nil, fmt.Errorf("alpha and beta must be between 0 and 1")
+	}
+	return test, nil
+}
+
+// bounds are the log-likelihood ratios at which H0 and H1 are accepted
+func (t *sprt) bounds() (float64, float64) {
+	return math.Log(t.beta / (1 - t.alpha)), math.Log((1 - t.beta) / t.alpha)
+}
+
+// llr approximates the log-likelihood ratio of the results so far, treating
+// the game results as normally distributed. Half a game of each outcome is
+// added as a prior so one-sided results still have a variance.
+func (t *sprt) llr(s score) float64 {
+	if s.games() == 0 {
+		return 0
+	}
+	wins, draws, losses := float64(s.wins)+0.5, float64(s.draws)+0.5, float64(s.losses)+0.5
+	n := wins + draws + losses
+	mean := (wins + 0.5*draws) / n
+	variance := (wins+0.25*draws)/n - mean*mean
+
+	s0, s1 := scoreFromElo(t.elo0), scoreFromElo(t.elo1)
+	return n * (s1 - s0) * (2*mean - s0 - s1) / (2 * variance)
+}
+
+// decision returns "H0" or "H1" once one is accepted, or an empty string
+func (t *sprt) decision(s score) string {
+	lower, upper := t.bounds()
+	switch llr := t.llr(s); {
+	case llr <= lower:
+		return "H0"
+	case llr >= upper:
+		return "H1"
+	}
+	return ""
+}
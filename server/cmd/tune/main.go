@@ -0,0 +1,168 @@
+// Command tune optimizes the evaluation weights of the bot against a set of
+// positions taken from games with known results (Texel's tuning method).
+//
+// Positions are read from EPD files, where each line holds a position and the
+// game result as "1-0", "0-1", "1/2-1/2" or [1.0], [0.5], [0.0], or sampled
+// from the games of PGN files. The tuned weights are written as JSON that can
+// be used as a bot personality.
+//
+//	go run ./cmd/tune -out tuned.json quiet-labeled.epd games.pgn
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+func main() {
+	paramsPath := flag.String("params", "", "JSON file with the starting weights (default built-in weights)")
+	out := flag.String("out", "", "file the tuned weights are written to (default standard output)")
+	passes := flag.Int("passes", 50, "maximum passes over all weights")
+	step := flag.Int("step", 1, "amount a weight is changed by in one try")
+	skip := flag.Int("skip", 8, "opening half-moves of PGN games that are not sampled")
+	maxPositions := flag.Int("max", 0, "use at most this many positions, picked at random (0 for all)")
+	concurrency := flag.Int("concurrency", runtime.NumCPU(), "goroutines evaluating positions")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tune [flags] positions.epd|games.pgn ...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	params := bot.DefaultEvalParams()
+	if *paramsPath != "" {
+		var err error
+		if params, err = bot.LoadEvalParams(*paramsPath); err != nil {
+			log.Fatalf("Failed to load weights: %v", err)
+		}
+	}
+
+	var positions []bot.TuningPosition
+	for _, path := range flag.Args() {
+		loaded, err := loadPositions(path, *skip)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		positions = append(positions, loaded...)
+	}
+	if len(positions) == 0 {
+		log.Fatal("No positions with results found")
+	}
+	if *maxPositions > 0 && len(positions) > *maxPositions {
+		rand.Shuffle(len(positions), func(i, j int) { positions[i], positions[j] = positions[j], positions[i] })
+		positions = positions[:*maxPositions]
+	}
+	fmt.Fprintf(os.Stderr, "Tuning on %d positions\n", len(positions))
+
+	result := bot.Tune(params, positions, bot.TuneOptions{
+		Passes:      *passes,
+		Step:        *step,
+		Concurrency: *concurrency,
+		Progress: func(pass int, err float64) {
+			fmt.Fprintf(os.Stderr, "pass %d: error %.6f\n", pass, err)
+		},
+	})
+	fmt.Fprintf(os.Stderr, "K %.3f, error %.6f -> %.6f\n", result.K, result.InitialError, result.Error)
+
+	data, err := json.MarshalIndent(result.Params, "", "\t")
+	if err != nil {
+		log.Fatalf("Failed to encode weights: %v", err)
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		log.Fatalf("Failed to write weights: %v", err)
+	}
+}
+
+// loadPositions reads the positions of an EPD or PGN file, chosen by extension
+func loadPositions(path string, skip int) ([]bot.TuningPosition, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".pgn") {
+		return readPGN(file, skip)
+	}
+	return readEPD(file)
+}
+
+// readEPD reads one position per line. Lines without a result are skipped.
+func readEPD(r io.Reader) ([]bot.TuningPosition, error) {
+	var positions []bot.TuningPosition
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		result, ok := parseResult(strings.Join(fields[4:], " "))
+		if !ok {
+			continue
+		}
+		pos, err := engine.ParseFEN(strings.Join(fields[:4], " ") + " 0 1")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		positions = append(positions, bot.TuningPosition{Pos: pos, Result: result})
+	}
+	return positions, scanner.Err()
+}
+
+// parseResult finds the game result in the operations of an EPD line
+func parseResult(ops string) (float64, bool) {
+	switch {
+	case strings.Contains(ops, "1/2-1/2") || strings.Contains(ops, "[0.5]"):
+		return 0.5, true
+	case strings.Contains(ops, "1-0") || strings.Contains(ops, "[1.0]"):
+		return 1, true
+	case strings.Contains(ops, "0-1") || strings.Contains(ops, "[0.0]"):
+		return 0, true
+	}
+	return 0, false
+}
+
+// readPGN samples the quiet positions of finished games: positions after the
+// opening where the side to move is not in check and does not capture or
+// promote next, since the tuner only looks at the static evaluation
+func readPGN(r io.Reader, skip int) ([]bot.TuningPosition, error) {
+	games, err := engine.ReadPGN(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []bot.TuningPosition
+	for _, game := range games {
+		result, ok := parseResult(game.Result)
+		if !ok {
+			continue
+		}
+		pos := game.Start
+		for ply, move := range game.Moves {
+			quiet := pos.Board[move.To] == engine.Empty && !move.IsEnPassant && move.Promotion == engine.NoPieceType
+			if ply >= skip && quiet && !engine.IsKingInCheck(pos, pos.Turn) {
+				positions = append(positions, bot.TuningPosition{Pos: pos, Result: result})
+			}
+			pos = engine.ApplyMove(pos, move)
+		}
+	}
+	return positions, nil
+}
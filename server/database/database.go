@@ -40,3 +40,52 @@ func UpdateUserELO(userID uint, newELO int) error {
 	}
 	return nil
 }
+
+// SaveGame stores a finished game
+func SaveGame(game *models.Game) error {
+	if err := DB.Create(game).Error; err != nil {
+		return fmt.Errorf("failed to save game: %w", err)
+	}
+	return nil
+}
+
+// GetGame loads a game by ID
+func GetGame(gameID uint) (*models.Game, error) {
+	var game models.Game
+	if err := DB.First(&game, gameID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load game %d: %w", gameID, err)
+	}
+	return &game, nil
+}
+
+// SaveGameReview creates or updates the review of a game
+func SaveGameReview(review *models.GameReview) error {
+	if err := DB.Save(review).Error; err != nil {
+		return fmt.Errorf("failed to save review for game %d: %w", review.GameID, err)
+	}
+	return nil
+}
+
+// GetGameReview loads the review of a game
+func GetGameReview(gameID uint) (*models.GameReview, error) {
+	var review models.GameReview
+	if err := DB.Where("game_id = ?", gameID).First(&review).Error; err != nil {
+		return nil, fmt.Errorf("failed to load review for game %d: %w", gameID, err)
+	}
+	return &review, nil
+}
+
+// SaveChatMessages stores chat messages of a room, attached to a game or to
+// none with a zero gameID
+func SaveChatMessages(gameID uint, messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	for i := range messages {
+		messages[i].GameID = gameID
+	}
+	if err := DB.Create(&messages).Error; err != nil {
+		return fmt.Errorf("failed to save chat of game %d: %w", gameID, err)
+	}
+	return nil
+}
@@ -272,6 +272,10 @@ type Position struct {
 	whiteKingPos Square
 	blackKingPos Square
 	positionHash uint64 // For threefold repetition detection
+
+	// prev is the position the last move was played in. It is only kept
+	// while positions can still repeat, that is until a capture or pawn move.
+	prev *Position
 }
 
 // NewGame creates a new game in the starting position.
@@ -663,9 +667,12 @@ func ApplyMove(pos *Position, move Move) *Position {
 
 	newPos.CastlingRights = newCastlingRights
 
-	// Reset half-move clock on pawn move or capture
+	// Reset half-move clock on pawn move or capture. Earlier positions can no
+	// longer repeat after such a move, so only reversible moves link back.
 	if movingPiece.Type() == Pawn || move.IsCapture {
 		newPos.HalfMoveClock = 0
+	} else {
+		newPos.prev = pos
 	}
 
 	// Update full-move number
@@ -676,6 +683,21 @@ func ApplyMove(pos *Position, move Move) *Position {
 	return newPos
 }
 
+// ApplyNullMove returns the position with the turn passed to the opponent
+// without a move being played. It is used to look for threats and is not
+// legal when the side to move is in check.
+func ApplyNullMove(pos *Position) *Position {
+	newPos := *pos
+	newPos.Turn = oppositeColor(pos.Turn)
+	newPos.EnPassant = NoSquare
+	newPos.HalfMoveClock++
+	newPos.prev = pos
+	if pos.Turn == Black {
+		newPos.FullMoveNumber++
+	}
+	return &newPos
+}
+
 // IsKingInCheck checks if the king of the given color is in check.
 func IsKingInCheck(pos *Position, color Color) bool {
 	// Get the king's position from cache
@@ -688,35 +710,33 @@ func IsKingInCheck(pos *Position, color Color) bool {
 		return false // Should not happen in a valid game
 	}
 
-	// Check for attacks from all opponent's pieces
-	opponentColor := oppositeColor(color)
+	return IsSquareAttacked(pos, kingSquare, oppositeColor(color))
+}
 
+// IsSquareAttacked checks if any piece of the attacker color attacks a square.
+func IsSquareAttacked(pos *Position, sq Square, attackerColor Color) bool {
 	// Pawn attacks
-	if isPawnAttacking(pos, kingSquare, opponentColor) {
+	if isPawnAttacking(pos, sq, attackerColor) {
 		return true
 	}
 
 	// Knight attacks
-	if isKnightAttacking(pos, kingSquare, opponentColor) {
+	if isKnightAttacking(pos, sq, attackerColor) {
 		return true
 	}
 
 	// Bishop and Queen (diagonal) attacks
-	if isSliderAttacking(pos, kingSquare, opponentColor, Bishop) {
+	if isSliderAttacking(pos, sq, attackerColor, Bishop) {
 		return true
 	}
 
 	// Rook and Queen (straight) attacks
-	if isSliderAttacking(pos, kingSquare, opponentColor, Rook) {
-		return true
-	}
-
-	// King attacks (should not happen in a legal position, but for completeness)
-	if isKingAttacking(pos, kingSquare, opponentColor) {
+	if isSliderAttacking(pos, sq, attackerColor, Rook) {
 		return true
 	}
 
-	return false
+	// King attacks
+	return isKingAttacking(pos, sq, attackerColor)
 }
 
 // GetGameStatus returns the current status of the game
@@ -744,6 +764,36 @@ func (pos *Position) GetGameStatus() GameStatus {
 	return InProgress
 }
 
+// HasMatingMaterial reports whether a side could still checkmate with the
+// opponent's help, which decides whether running out of time loses or draws.
+// A lone king cannot, nor can a king and a single minor piece against a lone
+// king.
+func HasMatingMaterial(pos *Position, color Color) bool {
+	pieces, minors, opponentPieces := 0, 0, 0
+	for sq := A1; sq <= H8; sq++ {
+		piece := pos.Board[sq]
+		if piece == Empty || piece.Type() == King {
+			continue
+		}
+		if piece.Color() != color {
+			opponentPieces++
+			continue
+		}
+		pieces++
+		if piece.Type() == Knight || piece.Type() == Bishop {
+			minors++
+		}
+	}
+
+	switch {
+	case pieces == 0:
+		return false
+	case pieces == 1 && minors == 1 && opponentPieces == 0:
+		return false
+	}
+	return true
+}
+
 // hasInsufficientMaterial checks if there is insufficient material for checkmate
 func hasInsufficientMaterial(pos *Position) bool {
 	// Count pieces
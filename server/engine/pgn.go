@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PGNGame is a game read from a PGN file
+type PGNGame struct {
+	Tags   map[string]string
+	Start  *Position
+	Moves  []Move
+	Result string // "1-0", "0-1", "1/2-1/2" or "*"
+}
+
+// ReadPGN reads every game of a PGN file. Comments, variations and numeric
+// annotation glyphs are skipped.
+func ReadPGN(r io.Reader) ([]*PGNGame, error) {
+	var games []*PGNGame
+	var tags map[string]string
+	var movetext strings.Builder
+
+	flush := func() error {
+		if tags == nil && strings.TrimSpace(movetext.String()) == "" {
+			return nil
+		}
+		game, err := parsePGNGame(tags, movetext.String())
+		if err != nil {
+			return fmt.Errorf("game %d: %w", len(games)+1, err)
+		}
+		games = append(games, game)
+		tags = nil
+		movetext.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "["):
+			// A tag after movetext starts the next game
+			if strings.TrimSpace(movetext.String()) != "" {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			if key, value, ok := parsePGNTag(line); ok {
+				tags[key] = value
+			}
+		case strings.HasPrefix(line, "%"):
+			// Escaped line
+		default:
+			movetext.WriteString(line)
+			movetext.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+// parsePGNTag parses a tag pair such as [White "Carlsen"]
+func parsePGNTag(line string) (string, string, bool) {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+	key, value, ok := strings.Cut(line, " ")
+	if !ok {
+		return "", "", false
+	}
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "\""), "\"")
+	return key, strings.ReplaceAll(value, "\\\"", "\""), true
+}
+
+func parsePGNGame(tags map[string]string, movetext string) (*PGNGame, error) {
+	game := &PGNGame{Tags: tags, Start: NewGame(), Result: "*"}
+	if game.Tags == nil {
+		game.Tags = make(map[string]string)
+	}
+	if fen, ok := game.Tags["FEN"]; ok {
+		start, err := ParseFEN(fen)
+		if err != nil {
+			return nil, err
+		}
+		game.Start = start
+	}
+	if result, ok := game.Tags["Result"]; ok {
+		game.Result = result
+	}
+
+	pos := game.Start
+	for _, token := range pgnTokens(movetext) {
+		switch token {
+		case "1-0", "0-1", "1/2-1/2", "*":
+			game.Result = token
+			continue
+		}
+		move, err := ParseSAN(pos, token)
+		if err != nil {
+			return nil, fmt.Errorf("move %d: %w", len(game.Moves)+1, err)
+		}
+		game.Moves = append(game.Moves, move)
+		pos = ApplyMove(pos, move)
+	}
+	return game, nil
+}
+
+// pgnTokens splits movetext into moves and results, dropping comments,
+// variations, move numbers and annotation glyphs
+func pgnTokens(movetext string) []string {
+	var tokens []string
+	var current strings.Builder
+	depth := 0 // Nesting of variations
+	inComment, inLineComment := false, false
+
+	endToken := func() {
+		if current.Len() == 0 {
+			return
+		}
+		token := current.String()
+		current.Reset()
+		if depth > 0 {
+			return
+		}
+		switch token {
+		case "1-0", "0-1", "1/2-1/2", "*":
+			tokens = append(tokens, token)
+			return
+		}
+		// Strip move numbers such as "12." or "12..." glued to the move
+		digits := strings.IndexFunc(token, func(r rune) bool { return r < '0' || r > '9' })
+		if digits > 0 && token[digits] == '.' {
+			token = strings.TrimLeft(token[digits:], ".")
+		}
+		if token == "" || digits == -1 || strings.HasPrefix(token, "$") {
+			return
+		}
+		tokens = append(tokens, token)
+	}
+
+	for _, r := range movetext {
+		switch {
+		case inComment:
+			inComment = r != '}'
+		case inLineComment:
+			inLineComment = r != '\n'
+		case r == '{':
+			endToken()
+			inComment = true
+		case r == ';':
+			endToken()
+			inLineComment = true
+		case r == '(':
+			endToken()
+			depth++
+		case r == ')':
+			endToken()
+			depth--
+		case r == ' ' || r == '\n' || r == '\t' || r == '\r':
+			endToken()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	endToken()
+	return tokens
+}
+
+// pgnTagOrder is the order of the Seven Tag Roster, written before any other tags
+var pgnTagOrder = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+
+// WritePGN writes a game in PGN. Missing roster tags are written as "?", and
+// a FEN tag is added for games that do not start from the initial position.
+func WritePGN(w io.Writer, game *PGNGame) error {
+	tags := make(map[string]string, len(game.Tags)+2)
+	for key, value := range game.Tags {
+		tags[key] = value
+	}
+	tags["Result"] = game.Result
+	if start := game.Start.String(); start != NewGame().String() {
+		tags["SetUp"] = "1"
+		tags["FEN"] = start
+	}
+
+	var out strings.Builder
+	for _, key := range pgnTagOrder {
+		value, ok := tags[key]
+		if !ok {
+			value = "?"
+		}
+		fmt.Fprintf(&out, "[%s \"%s\"]\n", key, strings.ReplaceAll(value, "\"", "\\\""))
+		delete(tags, key)
+	}
+	extra := make([]string, 0, len(tags))
+	for key := range tags {
+		extra = append(extra, key)
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		fmt.Fprintf(&out, "[%s \"%s\"]\n", key, strings.ReplaceAll(tags[key], "\"", "\\\""))
+	}
+	out.WriteByte('\n')
+
+	// Movetext, wrapped at 80 characters
+	var tokens []string
+	pos := game.Start
+	for i, move := range game.Moves {
+		if pos.Turn == White {
+			tokens = append(tokens, fmt.Sprintf("%d.", pos.FullMoveNumber))
+		} else if i == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d...", pos.FullMoveNumber))
+		}
+		tokens = append(tokens, MoveToSAN(pos, move))
+		pos = ApplyMove(pos, move)
+	}
+	tokens = append(tokens, game.Result)
+
+	lineLength := 0
+	for _, token := range tokens {
+		if lineLength > 0 && lineLength+1+len(token) > 80 {
+			out.WriteByte('\n')
+			lineLength = 0
+		} else if lineLength > 0 {
+			out.WriteByte(' ')
+			lineLength++
+		}
+		out.WriteString(token)
+		lineLength += len(token)
+	}
+	out.WriteString("\n\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
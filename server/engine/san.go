@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MoveToSAN returns the Standard Algebraic Notation of a legal move in the
+// given position (e.g. "Nf3", "exd5", "O-O", "e8=Q+").
+func MoveToSAN(pos *Position, move Move) string {
+	var san strings.Builder
+	piece := pos.Board[move.From]
+
+	switch {
+	case move.IsCastling && move.To%8 == 6:
+		san.WriteString("O-O")
+	case move.IsCastling:
+		san.WriteString("O-O-O")
+	default:
+		isCapture := pos.Board[move.To] != Empty || move.IsEnPassant
+		if piece.Type() == Pawn {
+			if isCapture {
+				san.WriteByte(move.From.String()[0])
+			}
+		} else {
+			san.WriteString(strings.ToUpper(piece.Type().String()))
+			san.WriteString(disambiguation(pos, move))
+		}
+		if isCapture {
+			san.WriteByte('x')
+		}
+		san.WriteString(move.To.String())
+		if move.Promotion != NoPieceType {
+			san.WriteByte('=')
+			san.WriteString(strings.ToUpper(move.Promotion.String()))
+		}
+	}
+
+	newPos := ApplyMove(pos, move)
+	if IsKingInCheck(newPos, newPos.Turn) {
+		if len(newPos.GenerateLegalMoves()) == 0 {
+			san.WriteByte('#')
+		} else {
+			san.WriteByte('+')
+		}
+	}
+	return san.String()
+}
+
+// disambiguation returns the file, rank or square needed to tell a piece move
+// apart from moves of identical pieces to the same square
+func disambiguation(pos *Position, move Move) string {
+	piece := pos.Board[move.From]
+	sameFile, sameRank, ambiguous := false, false, false
+
+	for _, other := range pos.GenerateLegalMoves() {
+		if other.To != move.To || other.From == move.From || pos.Board[other.From] != piece {
+			continue
+		}
+		ambiguous = true
+		if other.From%8 == move.From%8 {
+			sameFile = true
+		}
+		if other.From/8 == move.From/8 {
+			sameRank = true
+		}
+	}
+
+	from := move.From.String()
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return from[:1]
+	case !sameRank:
+		return from[1:]
+	default:
+		return from
+	}
+}
+
+// ParseSAN parses a move in Standard Algebraic Notation. Check, mate and
+// annotation suffixes are optional, as is the "=" before a promotion piece.
+func ParseSAN(pos *Position, san string) (Move, error) {
+	wanted := normalizeSAN(san)
+	if wanted == "" {
+		return Move{}, fmt.Errorf("invalid move format: %s", san)
+	}
+
+	for _, move := range pos.GenerateLegalMoves() {
+		if normalizeSAN(MoveToSAN(pos, move)) == wanted {
+			return move, nil
+		}
+	}
+	return Move{}, fmt.Errorf("illegal move: %s", san)
+}
+
+func normalizeSAN(san string) string {
+	san = strings.TrimRight(strings.TrimSpace(san), "+#!?")
+	san = strings.ReplaceAll(san, "=", "")
+	san = strings.ReplaceAll(san, "0", "O") // 0-0 is a common spelling of O-O
+	return san
+}
+
+// ParseMoveAny parses a move in either coordinate notation ("e2e4") or
+// Standard Algebraic Notation ("e4")
+func ParseMoveAny(pos *Position, moveStr string) (Move, error) {
+	if move, err := ParseMove(pos, moveStr); err == nil {
+		return move, nil
+	}
+	return ParseSAN(pos, moveStr)
+}
+
+// MovesToSAN converts a sequence of moves played from pos to SAN
+func MovesToSAN(pos *Position, moves []Move) []string {
+	sans := make([]string, 0, len(moves))
+	for _, move := range moves {
+		sans = append(sans, MoveToSAN(pos, move))
+		pos = ApplyMove(pos, move)
+	}
+	return sans
+}
@@ -0,0 +1,71 @@
+package engine
+
+// Random keys for Zobrist hashing
+var (
+	zobristPieces    [13][64]uint64
+	zobristBlackTurn uint64
+	zobristCastling  [4]uint64 // K, Q, k, q
+	zobristEnPassant [8]uint64 // By file
+)
+
+func init() {
+	// A fixed seed keeps hashes stable between runs
+	state := uint64(0x9E3779B97F4A7C15)
+	next := func() uint64 {
+		// splitmix64
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		return z ^ (z >> 31)
+	}
+
+	for piece := WhitePawn; piece <= BlackKing; piece++ {
+		for sq := A1; sq <= H8; sq++ {
+			zobristPieces[piece][sq] = next()
+		}
+	}
+	zobristBlackTurn = next()
+	for i := range zobristCastling {
+		zobristCastling[i] = next()
+	}
+	for i := range zobristEnPassant {
+		zobristEnPassant[i] = next()
+	}
+}
+
+// Hash returns the Zobrist hash of the position. Positions that differ only
+// in their move counters have the same hash.
+func (p *Position) Hash() uint64 {
+	var h uint64
+	for sq := A1; sq <= H8; sq++ {
+		if piece := p.Board[sq]; piece != Empty {
+			h ^= zobristPieces[piece][sq]
+		}
+	}
+	if p.Turn == Black {
+		h ^= zobristBlackTurn
+	}
+	for i, right := range "KQkq" {
+		for _, c := range p.CastlingRights {
+			if c == right {
+				h ^= zobristCastling[i]
+			}
+		}
+	}
+	if p.EnPassant != NoSquare {
+		h ^= zobristEnPassant[p.EnPassant%8]
+	}
+	return h
+}
+
+// History returns the hashes of the earlier positions of the game that can
+// still repeat, the most recent first. Only positions reached by ApplyMove
+// know their history; the list ends at the last capture or pawn move.
+func (p *Position) History() []uint64 {
+	var hashes []uint64
+	for prev, n := p.prev, p.HalfMoveClock; prev != nil && n > 0; prev, n = prev.prev, n-1 {
+		hashes = append(hashes, prev.Hash())
+	}
+	return hashes
+}
@@ -5,8 +5,10 @@ import (
 
 	"github.com/TLeTu/Chess-Media/server/authentication"
 	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/botgame"
 	"github.com/TLeTu/Chess-Media/server/database"
 	"github.com/TLeTu/Chess-Media/server/models"
+	"github.com/TLeTu/Chess-Media/server/review"
 	"github.com/TLeTu/Chess-Media/server/ws"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -26,8 +28,22 @@ func main() {
 	if err != nil {
 		log.Println("Error loading .env file, using environment variables if set")
 	}
+	if err := bot.LoadPersonalitiesFromEnv(); err != nil {
+		log.Fatalf("Failed to load bot personalities: %v", err)
+	}
+	if err := bot.ConfigureThreadsFromEnv(); err != nil {
+		log.Fatalf("Failed to configure bot threads: %v", err)
+	}
+	if err := ws.ConfigureReconnectFromEnv(); err != nil {
+		log.Fatalf("Failed to configure reconnections: %v", err)
+	}
+	if err := ws.ConfigureChatFromEnv(); err != nil {
+		log.Fatalf("Failed to configure the chat: %v", err)
+	}
+
 	database.Connect()
-	database.DB.AutoMigrate(&models.User{})
+	database.DB.AutoMigrate(&models.User{}, &models.Game{}, &models.GameReview{}, &models.ChatMessage{})
+	review.StartWorkers()
 
 	// Create and run the WebSocket hub
 	hub := ws.NewHub()
@@ -50,7 +66,21 @@ func main() {
 	api.Use(authentication.AuthMiddleware()) // Apply auth middleware to all /api routes
 	{
 		api.POST("/rooms/create", ws.CreateRoomHandler)
-		api.POST("/bot/move", bot.BotMoveHandler)
+		api.GET("/lobby", func(c *gin.Context) {
+			ws.LobbyHandler(hub, c)
+		})
+		api.POST("/bot/games", botgame.CreateGameHandler)
+		api.GET("/bot/games/:id", botgame.GetGameHandler)
+		api.POST("/bot/games/:id/move", botgame.MoveHandler)
+		api.POST("/bot/games/:id/resign", botgame.ResignHandler)
+		api.GET("/hint", botgame.HintHandler)
+		api.GET("/threats", botgame.ThreatsHandler)
+		api.GET("/bot/levels", bot.LevelsHandler)
+		api.GET("/bot/personalities", bot.PersonalitiesHandler)
+		api.POST("/analyze", bot.AnalyzeHandler)
+		api.GET("/eval/trace", bot.EvalTraceHandler)
+		api.GET("/mate", bot.MateHandler)
+		api.GET("/games/:id/review", review.GetReviewHandler)
 		api.GET("/validate", authentication.ValidateHandler)
 	}
 
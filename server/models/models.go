@@ -8,3 +8,46 @@ type User struct {
 	Password string
 	ELO      int `gorm:"default:1000"`
 }
+
+// Game results from White's point of view
+const (
+	ResultWhiteWins = "1-0"
+	ResultBlackWins = "0-1"
+	ResultDraw      = "1/2-1/2"
+)
+
+// Game is a finished game
+type Game struct {
+	gorm.Model
+	WhiteID     uint `gorm:"index"`
+	BlackID     uint `gorm:"index"`
+	StartFEN    string
+	Moves       string `gorm:"type:text"` // Space separated, coordinate notation
+	Result      string
+	Termination string // checkmate, stalemate, ...
+	Ranked      bool
+
+	// Games against the bot have the bot's side ID set to 0
+	BotLevel       int `gorm:"default:0"` // 0 for games between users
+	BotPersonality string
+	Hints          int // Hints the user asked for during a game against the bot
+}
+
+// ChatMessage is a message sent in the chat of a room, kept for moderation
+type ChatMessage struct {
+	gorm.Model
+	GameID   uint   `gorm:"index"` // Last saved game of the room, 0 if none
+	RoomID   string `gorm:"index"`
+	UserID   uint   `gorm:"index"`
+	Channel  string // "players" or "spectators"
+	Text     string `gorm:"type:text"` // As written, before filtering
+	Filtered bool   // Words were hidden by the profanity filter
+}
+
+// GameReview is the engine review of a finished game
+type GameReview struct {
+	gorm.Model
+	GameID uint   `gorm:"uniqueIndex"`
+	Status string // "pending", "done" or "failed"
+	Data   string `gorm:"type:longtext"` // JSON encoded review.Review
+}
@@ -0,0 +1,56 @@
+package review
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/TLeTu/Chess-Media/server/database"
+	"github.com/TLeTu/Chess-Media/server/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GetReviewHandler returns the review of a game to one of its players. While
+// the review is still running it responds with 202 Accepted and the review
+// status.
+func GetReviewHandler(c *gin.Context) {
+	gameID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	value, exists := c.Get("user")
+	user, ok := value.(models.User)
+	if !exists || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	// Games of other users are reported as missing, like in the bot game
+	// handlers
+	game, err := database.GetGame(uint(gameID))
+	if err != nil || (game.WhiteID != user.ID && game.BlackID != user.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return
+	}
+
+	record, err := database.GetGameReview(uint(gameID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+
+	switch record.Status {
+	case StatusDone:
+		var review Review
+		if err := json.Unmarshal([]byte(record.Data), &review); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid review data"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": record.Status, "review": review})
+	case StatusPending:
+		c.JSON(http.StatusAccepted, gin.H{"status": record.Status})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": record.Status})
+	}
+}
@@ -0,0 +1,104 @@
+package review
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/TLeTu/Chess-Media/server/database"
+	"github.com/TLeTu/Chess-Media/server/models"
+)
+
+// Review statuses
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	defaultWorkers   = 1
+	defaultQueueSize = 100
+)
+
+// jobs holds the IDs of games waiting to be reviewed. It is nil until
+// StartWorkers is called.
+var jobs chan uint
+
+// StartWorkers starts the pool reviewing finished games. The pool is small and
+// the queue bounded so reviews never compete with live games for long; when the
+// queue is full new games are not reviewed. The number of workers can be set
+// with REVIEW_WORKERS.
+func StartWorkers() {
+	workers := defaultWorkers
+	if value := os.Getenv("REVIEW_WORKERS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			workers = n
+		} else {
+			log.Printf("Invalid REVIEW_WORKERS %q, using %d", value, defaultWorkers)
+		}
+	}
+
+	jobs = make(chan uint, defaultQueueSize)
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+	log.Printf("Started %d game review workers", workers)
+}
+
+// Enqueue schedules the review of a finished game without blocking
+func Enqueue(gameID uint) {
+	record := &models.GameReview{GameID: gameID, Status: StatusPending}
+	if err := database.SaveGameReview(record); err != nil {
+		log.Printf("Error creating review for game %d: %v", gameID, err)
+		return
+	}
+
+	select {
+	case jobs <- gameID:
+	default:
+		log.Printf("Review queue full, game %d will not be reviewed", gameID)
+		record.Status = StatusFailed
+		if err := database.SaveGameReview(record); err != nil {
+			log.Printf("Error saving review for game %d: %v", gameID, err)
+		}
+	}
+}
+
+func worker() {
+	for gameID := range jobs {
+		process(gameID)
+	}
+}
+
+// process reviews a game and stores the result
+func process(gameID uint) {
+	record, err := database.GetGameReview(gameID)
+	if err != nil {
+		log.Printf("Error reviewing game %d: %v", gameID, err)
+		return
+	}
+
+	game, err := database.GetGame(gameID)
+	if err == nil {
+		var review *Review
+		if review, err = Analyze(game); err == nil {
+			var data []byte
+			if data, err = json.Marshal(review); err == nil {
+				record.Status = StatusDone
+				record.Data = string(data)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Error reviewing game %d: %v", gameID, err)
+		record.Status = StatusFailed
+	}
+
+	if err := database.SaveGameReview(record); err != nil {
+		log.Printf("Error saving review for game %d: %v", gameID, err)
+		return
+	}
+	log.Printf("Review of game %d finished with status %s", gameID, record.Status)
+}
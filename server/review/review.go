@@ -0,0 +1,222 @@
+package review
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/TLeTu/Chess-Media/server/models"
+)
+
+// reviewDepth is the search depth used for every position of a game. It is
+// kept low so a review takes seconds rather than minutes.
+const reviewDepth = 3
+
+// Scores are capped at this many centipawns so a missed mate does not count
+// as an enormous loss
+const maxEvalCP = 1500
+
+// Move classifications
+const (
+	Best       = "best"
+	Good       = "good"
+	Inaccuracy = "inaccuracy"
+	Mistake    = "mistake"
+	Blunder    = "blunder"
+)
+
+// Centipawn loss thresholds of the classifications
+const (
+	inaccuracyLoss = 50
+	mistakeLoss    = 100
+	blunderLoss    = 300
+)
+
+// MoveAnnotation describes one move of a reviewed game
+type MoveAnnotation struct {
+	Ply            int       `json:"ply"`
+	Color          string    `json:"color"`
+	SAN            string    `json:"san"`
+	UCI            string    `json:"uci"`
+	BestMove       string    `json:"bestMove"` // SAN
+	EvalBefore     bot.Score `json:"evalBefore"`
+	EvalAfter      bot.Score `json:"evalAfter"`
+	CPLoss         int       `json:"cpLoss"`
+	Accuracy       float64   `json:"accuracy"`
+	Classification string    `json:"classification"`
+}
+
+// PlayerSummary aggregates the moves of one player
+type PlayerSummary struct {
+	UserID       uint    `json:"userId"`
+	ACPL         int     `json:"acpl"` // Average centipawn loss
+	Accuracy     float64 `json:"accuracy"`
+	Best         int     `json:"best"`
+	Inaccuracies int     `json:"inaccuracies"`
+	Mistakes     int     `json:"mistakes"`
+	Blunders     int     `json:"blunders"`
+}
+
+// Review is the annotated result of a game
+type Review struct {
+	GameID uint             `json:"gameId"`
+	Depth  int              `json:"depth"`
+	White  PlayerSummary    `json:"white"`
+	Black  PlayerSummary    `json:"black"`
+	Moves  []MoveAnnotation `json:"moves"`
+}
+
+// reviewBot searches the positions of reviewed games. It is single-threaded
+// so reviews never take helper threads away from live games.
+var reviewBot = bot.NewChessBot(reviewDepth)
+
+// evaluation is the search result of one position of the game
+type evaluation struct {
+	score    bot.Score // From White's point of view
+	cp       int       // Capped centipawns from White's point of view
+	bestMove engine.Move
+}
+
+// Analyze reviews a finished game
+func Analyze(game *models.Game) (*Review, error) {
+	pos := engine.NewGame()
+	if game.StartFEN != "" {
+		var err error
+		if pos, err = engine.ParseFEN(game.StartFEN); err != nil {
+			return nil, fmt.Errorf("invalid start position: %w", err)
+		}
+	}
+
+	review := &Review{
+		GameID: game.ID,
+		Depth:  reviewDepth,
+		White:  PlayerSummary{UserID: game.WhiteID},
+		Black:  PlayerSummary{UserID: game.BlackID},
+	}
+
+	before := evaluate(pos)
+	for i, moveStr := range strings.Fields(game.Moves) {
+		move, err := engine.ParseMove(pos, moveStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid move %d: %w", i+1, err)
+		}
+		next := engine.ApplyMove(pos, move)
+		after := evaluate(next)
+
+		// Losses are measured from the mover's point of view
+		sign := 1
+		if pos.Turn == engine.Black {
+			sign = -1
+		}
+		loss := max(0, sign*(before.cp-after.cp))
+		accuracy := moveAccuracy(sign*before.cp, sign*after.cp)
+
+		annotation := MoveAnnotation{
+			Ply:        i + 1,
+			Color:      colorName(pos.Turn),
+			SAN:        engine.MoveToSAN(pos, move),
+			UCI:        move.String(),
+			EvalBefore: before.score,
+			EvalAfter:  after.score,
+			CPLoss:     loss,
+			Accuracy:   math.Round(accuracy*10) / 10,
+		}
+		if before.bestMove.From != before.bestMove.To {
+			annotation.BestMove = engine.MoveToSAN(pos, before.bestMove)
+		}
+		annotation.Classification = classify(move, before.bestMove, loss)
+		review.Moves = append(review.Moves, annotation)
+
+		pos, before = next, after
+	}
+
+	summarize(&review.White, review.Moves, "white")
+	summarize(&review.Black, review.Moves, "black")
+	return review, nil
+}
+
+// evaluate searches a position and converts the result to White's point of view
+func evaluate(pos *engine.Position) evaluation {
+	switch pos.GetGameStatus() {
+	case engine.InProgress:
+		result := reviewBot.Search(pos)
+		score := bot.NewScore(result.Score, pos.Turn)
+		cp := max(-maxEvalCP, min(maxEvalCP, score.Centipawns()))
+		return evaluation{score: score, cp: cp, bestMove: result.Move}
+	case engine.Checkmate:
+		// The side to move is mated
+		mate, cp := 0, maxEvalCP
+		if pos.Turn == engine.White {
+			cp = -maxEvalCP
+		}
+		return evaluation{score: bot.Score{Mate: &mate}, cp: cp}
+	default:
+		cp := 0
+		return evaluation{score: bot.Score{CP: &cp}}
+	}
+}
+
+// winPercent converts centipawns into a winning chance between 0 and 100
+func winPercent(cp int) float64 {
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*float64(cp)))-1)
+}
+
+// moveAccuracy rates a move between 0 and 100 by how much winning chance it
+// gave away. Both scores are from the mover's point of view.
+func moveAccuracy(beforeCP, afterCP int) float64 {
+	drop := math.Max(0, winPercent(beforeCP)-winPercent(afterCP))
+	accuracy := 103.1668*math.Exp(-0.04354*drop) - 3.1669
+	return math.Max(0, math.Min(100, accuracy))
+}
+
+func classify(move, bestMove engine.Move, loss int) string {
+	switch {
+	case move.From == bestMove.From && move.To == bestMove.To && move.Promotion == bestMove.Promotion:
+		return Best
+	case loss >= blunderLoss:
+		return Blunder
+	case loss >= mistakeLoss:
+		return Mistake
+	case loss >= inaccuracyLoss:
+		return Inaccuracy
+	default:
+		return Good
+	}
+}
+
+// summarize fills in a player's summary from their annotated moves
+func summarize(summary *PlayerSummary, moves []MoveAnnotation, color string) {
+	count, totalLoss, totalAccuracy := 0, 0, 0.0
+	for _, move := range moves {
+		if move.Color != color {
+			continue
+		}
+		count++
+		totalLoss += move.CPLoss
+		totalAccuracy += move.Accuracy
+
+		switch move.Classification {
+		case Best:
+			summary.Best++
+		case Inaccuracy:
+			summary.Inaccuracies++
+		case Mistake:
+			summary.Mistakes++
+		case Blunder:
+			summary.Blunders++
+		}
+	}
+	if count > 0 {
+		summary.ACPL = totalLoss / count
+		summary.Accuracy = math.Round(totalAccuracy/float64(count)*10) / 10
+	}
+}
+
+func colorName(c engine.Color) string {
+	if c == engine.White {
+		return "white"
+	}
+	return "black"
+}
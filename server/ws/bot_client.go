@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// AddBotPayload is sent by the host to seat the computer as the guest
+type AddBotPayload struct {
+	Level       int    `json:"level"`
+	Personality string `json:"personality,omitempty"`
+
+	// Streams the bot's search to its opponent too and lets them cut it
+	// short. Off, only spectators see it and the bot always thinks in full.
+	ShowThinking bool `json:"show_thinking,omitempty"`
+}
+
+// newBotClient creates a client played by the computer. It has no websocket
+// connection: the messages the room sends to it are read by play, and its
+// moves go through the room's Broadcast channel like those of human players.
+func newBotClient(room *Room, level int, personality string) (*Client, error) {
+	if level == 0 {
+		level = bot.DefaultLevel
+	}
+	if personality == "" {
+		personality = bot.DefaultPersonality
+	}
+	chessBot, err := bot.GetBot(level, personality)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		Hub:            room.Hub,
+		Room:           room,
+		Send:           make(chan []byte, 256),
+		RoomID:         room.ID,
+		IsBot:          true,
+		BotLevel:       level,
+		BotPersonality: personality,
+		stopSearch:     make(chan struct{}, 1),
+	}
+	go client.play(chessBot)
+	return client, nil
+}
+
+// play answers every game state in which it is the bot's turn with a move,
+// declines draw offers and accepts takebacks and rematches. It returns when
+// the room closes the Send channel.
+func (c *Client) play(chessBot *bot.ChessBot) {
+	// The color is taken from the player_assigned message rather than
+	// PlayerColor, which belongs to the room goroutine
+	color := engine.NoColor
+
+	for messageBytes := range c.Send {
+		var msg Message
+		if err := json.Unmarshal(messageBytes, &msg); err != nil {
+			continue
+		}
+		payloadBytes, _ := json.Marshal(msg.Payload)
+
+		switch msg.Action {
+		case "player_assigned":
+			var assignment PlayerAssignmentPayload
+			json.Unmarshal(payloadBytes, &assignment)
+			switch assignment.Color {
+			case "white":
+				color = engine.White
+			case "black":
+				color = engine.Black
+			}
+		case "draw_offer":
+			// The computer plays every game out
+			var offer DrawOfferPayload
+			json.Unmarshal(payloadBytes, &offer)
+			if offer.Color != colorName(color) {
+				c.Room.Broadcast <- &ClientMessage{Client: c, Message: &Message{Action: "decline_draw"}}
+			}
+		case "takeback_request":
+			// The computer lets its opponent take moves back
+			var request TakebackPayload
+			json.Unmarshal(payloadBytes, &request)
+			if request.Color != colorName(color) {
+				c.Room.Broadcast <- &ClientMessage{Client: c, Message: &Message{Action: "accept_takeback"}}
+			}
+		case "rematch_offer":
+			var offer RematchPayload
+			json.Unmarshal(payloadBytes, &offer)
+			if offer.Color != colorName(color) {
+				c.Room.Broadcast <- &ClientMessage{Client: c, Message: &Message{Action: "accept_rematch"}}
+			}
+		case "game_state":
+			var state GameStatePayload
+			json.Unmarshal(payloadBytes, &state)
+			if state.Result != "" || state.GameStatus != engine.InProgress.String() {
+				continue
+			}
+			pos, err := engine.ParseFEN(state.FEN)
+			if err != nil {
+				log.Printf("Bot in room %s received an invalid FEN: %v", c.RoomID, err)
+				continue
+			}
+			if pos.Turn != color {
+				continue
+			}
+
+			move := c.think(chessBot, pos, moveBudget(state.Clock, color))
+			payload := MovePayload{From: move.From.String(), To: move.To.String()}
+			if move.Promotion != engine.NoPieceType {
+				payload.Promotion = move.Promotion.String()
+			}
+			c.Room.Broadcast <- &ClientMessage{
+				Client:  c,
+				Message: &Message{Action: "move", Payload: &payload},
+			}
+		}
+	}
+}
+
+// think searches for a move, streaming the progress to the room as
+// engine_info messages. A stop_search request of the opponent when the bot
+// shows its thinking, the end of the game or of the time budget, if not zero,
+// end the search early with the best move found so far.
+func (c *Client) think(chessBot *bot.ChessBot, pos *engine.Position, budget time.Duration) engine.Move {
+	// Requests made while the bot was not thinking are stale
+	select {
+	case <-c.stopSearch:
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.stopSearch:
+			close(stop)
+		case <-timeout:
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	return chessBot.BestMoveWithControl(pos, bot.SearchControl{
+		Info: func(info bot.SearchInfo) {
+			c.Room.postEngineInfo(nil, engineSourceBot, info)
+		},
+		Stop: stop,
+	})
+}
+
+// moveBudget is the time the bot allows itself for a move of a timed game,
+// leaving room for the moves to come. Untimed games have no budget.
+func moveBudget(clock *ClockPayload, color engine.Color) time.Duration {
+	if clock == nil {
+		return 0
+	}
+	remaining := time.Duration(clock.WhiteMs) * time.Millisecond
+	if color == engine.Black {
+		remaining = time.Duration(clock.BlackMs) * time.Millisecond
+	}
+	bonus := time.Duration(clock.TimeControl.IncrementMs+clock.TimeControl.DelayMs) * time.Millisecond
+	return max(time.Millisecond, min(remaining/2, remaining/30+bonus*3/4))
+}
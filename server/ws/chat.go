@@ -0,0 +1,237 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TLeTu/Chess-Media/server/database"
+	"github.com/TLeTu/Chess-Media/server/models"
+)
+
+// Chat channels. Players and spectators cannot read each other's chat.
+const (
+	ChatPlayers    = "players"
+	ChatSpectators = "spectators"
+)
+
+// Limits of the chat
+const (
+	maxChatLength   = 140             // Characters per message
+	maxChatLog      = 1000            // Messages kept per game
+	chatBurst       = 5               // Messages a user can send at once
+	chatRefillEvery = 2 * time.Second // Time to earn one more message
+)
+
+// blockedWords are hidden by the profanity filter. The list can be replaced
+// with CHAT_WORD_LIST.
+var blockedWords = map[string]bool{
+	"fuck": true, "fucking": true, "shit": true, "bitch": true, "cunt": true,
+	"asshole": true, "bastard": true, "dick": true, "retard": true, "whore": true,
+}
+
+var chatWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// ConfigureChatFromEnv reads CHAT_WORD_LIST, a file with one blocked word per
+// line replacing the default list. It must be called before any room is
+// created.
+func ConfigureChatFromEnv() error {
+	path := os.Getenv("CHAT_WORD_LIST")
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open CHAT_WORD_LIST: %w", err)
+	}
+	defer file.Close()
+
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if word := strings.ToLower(strings.TrimSpace(scanner.Text())); word != "" && !strings.HasPrefix(word, "#") {
+			words[word] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read CHAT_WORD_LIST: %w", err)
+	}
+	blockedWords = words
+	return nil
+}
+
+// filterProfanity hides blocked words behind asterisks and reports whether
+// any was found
+func filterProfanity(text string) (string, bool) {
+	filtered := false
+	text = chatWord.ReplaceAllStringFunc(text, func(word string) string {
+		if !blockedWords[strings.ToLower(word)] {
+			return word
+		}
+		filtered = true
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+	return text, filtered
+}
+
+// ChatPayload is sent by a client as "chat" and broadcast to the channel of
+// the sender
+type ChatPayload struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"` // Set by the server
+	UserID  uint   `json:"user_id,omitempty"` // Set by the server
+	Role    string `json:"role,omitempty"`    // "white", "black", "player" or "spectator", set by the server
+	SentAt  int64  `json:"sent_at,omitempty"` // Unix milliseconds, set by the server
+}
+
+// MutePayload is sent by a moderator as "mute" or "unmute" and broadcast as
+// "chat_muted" or "chat_unmuted"
+type MutePayload struct {
+	UserID uint `json:"user_id"`
+}
+
+// chatLimiter is a token bucket limiting how fast a user can chat
+type chatLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is left
+func (l *chatLimiter) allow(now time.Time) bool {
+	l.tokens = min(chatBurst, l.tokens+now.Sub(l.last).Seconds()/chatRefillEvery.Seconds())
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// chatChannel is the channel a client writes to and reads
+func (r *Room) chatChannel(client *Client) string {
+	if r.Spectators[client] {
+		return ChatSpectators
+	}
+	return ChatPlayers
+}
+
+// handleChat checks and filters a chat message and sends it to the sender's
+// channel. The original text is kept for the game record.
+func (r *Room) handleChat(sender *Client, chat *ChatPayload) {
+	if sender.IsBot {
+		return
+	}
+
+	text := strings.TrimSpace(chat.Text)
+	switch {
+	case text == "":
+		return
+	case utf8.RuneCountInString(text) > maxChatLength:
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, fmt.Sprintf("Chat messages are limited to %d characters.", maxChatLength))
+		return
+	case r.muted[sender.UserID]:
+		r.sendErrorMessage(sender, ErrCodeMuted, "You have been muted.")
+		return
+	}
+
+	now := time.Now()
+	limiter, ok := r.chatLimits[sender.UserID]
+	if !ok {
+		limiter = &chatLimiter{tokens: chatBurst, last: now}
+		r.chatLimits[sender.UserID] = limiter
+	}
+	if !limiter.allow(now) {
+		r.sendErrorMessage(sender, ErrCodeRateLimited, "You are sending messages too fast.")
+		return
+	}
+
+	channel := r.chatChannel(sender)
+	shown, filtered := filterProfanity(text)
+	if len(r.chatLog) < maxChatLog {
+		r.chatLog = append(r.chatLog, models.ChatMessage{
+			RoomID:   r.ID,
+			UserID:   sender.UserID,
+			Channel:  channel,
+			Text:     text,
+			Filtered: filtered,
+		})
+	}
+
+	role := "spectator"
+	if channel == ChatPlayers {
+		role = colorName(sender.PlayerColor)
+		if role == "" {
+			role = "player"
+		}
+	}
+	message := Message{Action: "chat", Payload: ChatPayload{
+		Text:    shown,
+		Channel: channel,
+		UserID:  sender.UserID,
+		Role:    role,
+		SentAt:  now.UnixMilli(),
+	}}
+	messageBytes, _ := json.Marshal(message)
+	for client := range r.getAllClients() {
+		if !client.IsBot && r.chatChannel(client) == channel {
+			client.Send <- messageBytes
+		}
+	}
+}
+
+// saveChat stores the chat written since it was last saved, so that chat
+// after the result or in games that were never saved is kept for moderation
+// too. It is attached to the last saved game of the room, if any.
+func (r *Room) saveChat() {
+	if err := database.SaveChatMessages(r.savedGameID, r.chatLog); err != nil {
+		log.Printf("Error saving chat of room %s: %v", r.ID, err)
+	}
+	r.chatLog = nil
+}
+
+// isModerator reports whether a client may mute users. The host moderates
+// the chat of their room. Rooms without a host, ranked rooms and rooms of
+// accepted seeks, are moderated by their players; a player muting their
+// opponent only silences them in the players' channel, which the spectators
+// do not read anyway.
+func (r *Room) isModerator(client *Client) bool {
+	if r.Host != nil {
+		return client == r.Host
+	}
+	return r.isPlayer(client) && !client.IsBot
+}
+
+// handleMute lets a moderator silence a user in the chat, or lift it
+func (r *Room) handleMute(sender *Client, target *MutePayload, mute bool) {
+	if !r.isModerator(sender) {
+		if r.Host != nil {
+			r.sendErrorMessage(sender, ErrCodeForbidden, "Only the host can mute users.")
+		} else {
+			r.sendErrorMessage(sender, ErrCodeForbidden, "Only the players can mute users.")
+		}
+		return
+	}
+	if target.UserID == 0 {
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, "Invalid user to mute.")
+		return
+	}
+	if target.UserID == sender.UserID {
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, "You cannot mute yourself.")
+		return
+	}
+
+	action := "chat_unmuted"
+	if mute {
+		r.muted[target.UserID] = true
+		action = "chat_muted"
+	} else {
+		delete(r.muted, target.UserID)
+	}
+	r.broadcastMessage(Message{Action: action, Payload: *target})
+}
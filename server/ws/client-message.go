@@ -4,4 +4,5 @@ package ws
 type ClientMessage struct {
 	Client  *Client
 	Message *Message
+	Error   *protocolError // Set if the message could not be decoded, the room replies with it
 }
@@ -1,7 +1,6 @@
 package ws
 
 import (
-	"encoding/json"
 	"log"
 	"time"
 
@@ -20,8 +19,10 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. The largest valid message is
+	// a chat of maxChatLength characters, which may take up to 12 bytes each
+	// when escaped in JSON, plus the envelope.
+	maxMessageSize = 12*maxChatLength + 1024
 )
 
 var upgrader = websocket.Upgrader{
@@ -46,8 +47,18 @@ type Client struct {
 
 	PlayerColor engine.Color
 	RoomID      string
-	UserELO     int // User's ELO rating
+	UserELO     int          // User's ELO rating
 	User        *models.User // Reference to the authenticated user
+
+	IsBot          bool // Played by the computer, see bot_client.go
+	BotLevel       int  // Bot level and personality of computer players
+	BotPersonality string
+	ShowThinking   bool          // The bot's opponent sees its search and may stop it, see engine_info.go
+	stopSearch     chan struct{} // Makes a computer player move now, see handleStopSearch
+
+	disconnected bool // The connection dropped and the seat is held, see reconnect.go
+
+	ProtocolVersion int // Agreed in the handshake, see protocol.go
 }
 
 // readPump pumps messages from the websocket connection to the hub
@@ -70,17 +81,22 @@ func (c *Client) readPump() {
 			}
 			break
 		}
-		var msg Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("error unmarshaling message: %v", err)
-			continue // Ignore malformed messages ?
+		// Messages that cannot be decoded are sent to the room as well,
+		// which owns the Send channel and answers them with an error
+		msg, protocolErr := decodeClientMessage(messageBytes)
+		if protocolErr != nil {
+			log.Printf("Client %d sent an invalid message: %v", c.UserID, protocolErr)
 		}
-		// Send the structured message to the client's room
 		clientMsg := &ClientMessage{
 			Client:  c,
-			Message: &msg,
+			Message: msg,
+			Error:   protocolErr,
+		}
+		if c.RoomID == lobbyRoomID {
+			c.Hub.Lobby.handleMessage(clientMsg)
+		} else {
+			c.Room.Broadcast <- clientMsg
 		}
-		c.Room.Broadcast <- clientMsg
 	}
 }
 
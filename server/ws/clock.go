@@ -0,0 +1,279 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/TLeTu/Chess-Media/server/models"
+)
+
+// Delay types of a time control
+const (
+	DelayNone      = ""
+	DelayBronstein = "bronstein" // The time used is given back after the move, up to the delay
+	DelaySimple    = "simple"    // The clock only starts once the delay has passed
+)
+
+// Limits of time controls chosen by hosts
+const (
+	maxBaseTime  = 3 * time.Hour
+	maxIncrement = time.Minute
+	maxDelay     = time.Minute
+)
+
+// premoveTime is the time charged for a premove, which is played the moment
+// the player's turn begins
+const premoveTime = 100 * time.Millisecond
+
+// rankedTimeControl is played by every ranked game
+var rankedTimeControl = TimeControl{Base: 10 * time.Minute, Increment: 5 * time.Second}
+
+// TimeControl is the time each player has for the game. The Fischer
+// increment is added after every move. A delay lets a player use some time
+// on every move without losing any, see DelayBronstein and DelaySimple.
+type TimeControl struct {
+	Base      time.Duration
+	Increment time.Duration
+	Delay     time.Duration
+	DelayType string
+}
+
+// TimeControlPayload is a time control in milliseconds, sent by the host as
+// "set_time_control" and included in lobby states. A zero base time makes
+// the game untimed.
+type TimeControlPayload struct {
+	BaseMs      int64  `json:"base_ms"`
+	IncrementMs int64  `json:"increment_ms"`
+	DelayMs     int64  `json:"delay_ms"`
+	DelayType   string `json:"delay_type,omitempty"`
+}
+
+// ClockPayload is the state of the clocks in a "game_state". The remaining
+// times are those at the moment the message was sent; the running clock
+// keeps counting down from there.
+type ClockPayload struct {
+	WhiteMs     int64              `json:"white_ms"`
+	BlackMs     int64              `json:"black_ms"`
+	Running     string             `json:"running,omitempty"` // "white" or "black", empty once stopped
+	TimeControl TimeControlPayload `json:"time_control"`
+}
+
+func newTimeControl(p TimeControlPayload) (TimeControl, error) {
+	tc := TimeControl{
+		Base:      time.Duration(p.BaseMs) * time.Millisecond,
+		Increment: time.Duration(p.IncrementMs) * time.Millisecond,
+		Delay:     time.Duration(p.DelayMs) * time.Millisecond,
+		DelayType: p.DelayType,
+	}
+	switch {
+	case tc.Base <= 0 || tc.Base > maxBaseTime:
+		return tc, fmt.Errorf("the base time must be between 0 and %v", maxBaseTime)
+	case tc.Increment < 0 || tc.Increment > maxIncrement:
+		return tc, fmt.Errorf("the increment must be between 0 and %v", maxIncrement)
+	case tc.Delay < 0 || tc.Delay > maxDelay:
+		return tc, fmt.Errorf("the delay must be between 0 and %v", maxDelay)
+	case tc.DelayType != DelayNone && tc.DelayType != DelayBronstein && tc.DelayType != DelaySimple:
+		return tc, fmt.Errorf("unknown delay type %q", tc.DelayType)
+	case (tc.DelayType == DelayNone) != (tc.Delay == 0):
+		return tc, fmt.Errorf("a delay needs both a type and a length")
+	}
+	return tc, nil
+}
+
+func (tc TimeControl) payload() TimeControlPayload {
+	return TimeControlPayload{
+		BaseMs:      tc.Base.Milliseconds(),
+		IncrementMs: tc.Increment.Milliseconds(),
+		DelayMs:     tc.Delay.Milliseconds(),
+		DelayType:   tc.DelayType,
+	}
+}
+
+// clock keeps the time of both players. It belongs to the room goroutine,
+// which waits on timer to notice a flag fall.
+type clock struct {
+	tc        TimeControl
+	remaining map[engine.Color]time.Duration
+	running   engine.Color // NoColor while stopped
+	turnStart time.Time
+	timer     *time.Timer
+}
+
+func newClock(tc TimeControl) *clock {
+	return &clock{
+		tc:        tc,
+		remaining: map[engine.Color]time.Duration{engine.White: tc.Base, engine.Black: tc.Base},
+		running:   engine.NoColor,
+	}
+}
+
+// start runs the clock of a side from now on
+func (c *clock) start(color engine.Color, now time.Time) {
+	c.running = color
+	c.turnStart = now
+
+	// The flag falls once the remaining time is used up, which with simple
+	// delay starts counting only after the delay
+	limit := c.remaining[color]
+	if c.tc.DelayType == DelaySimple {
+		limit += c.tc.Delay
+	}
+	if c.timer == nil {
+		c.timer = time.NewTimer(limit)
+	} else {
+		c.timer.Reset(limit)
+	}
+}
+
+// stop stops both clocks for good
+func (c *clock) stop() {
+	c.running = engine.NoColor
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// used is the time counted against the running side so far on this move
+func (c *clock) used(now time.Time) time.Duration {
+	return c.charged(now.Sub(c.turnStart))
+}
+
+// charged is the time counted against a side that thought for elapsed
+func (c *clock) charged(elapsed time.Duration) time.Duration {
+	if c.tc.DelayType == DelaySimple {
+		return max(0, elapsed-c.tc.Delay)
+	}
+	return elapsed
+}
+
+// timeLeft is the remaining time of a side at a given moment
+func (c *clock) timeLeft(color engine.Color, now time.Time) time.Duration {
+	if color != c.running {
+		return c.remaining[color]
+	}
+	return c.remaining[color] - c.used(now)
+}
+
+// flagged reports whether the running side has run out of time
+func (c *clock) flagged(now time.Time) bool {
+	return c.running != engine.NoColor && c.timeLeft(c.running, now) <= 0
+}
+
+// press ends the move of the running side, adding its increment or delay,
+// and starts the opponent's clock. It returns false without changing
+// anything when the move came too late.
+func (c *clock) press(now time.Time) bool {
+	if c.flagged(now) {
+		return false
+	}
+	c.settle(now.Sub(c.turnStart))
+	c.start(c.running.Opponent(), now)
+	return true
+}
+
+// pressPremove is press for a premove, charging premoveTime however long the
+// room took to play it. A player with less time left than that is charged
+// the time actually used.
+func (c *clock) pressPremove(now time.Time) bool {
+	if c.flagged(c.turnStart.Add(premoveTime)) {
+		return c.press(now)
+	}
+	c.settle(premoveTime)
+	c.start(c.running.Opponent(), now)
+	return true
+}
+
+// settle charges the running side for a move it thought elapsed about and
+// adds its increment or delay
+func (c *clock) settle(elapsed time.Duration) {
+	color := c.running
+	c.remaining[color] -= c.charged(elapsed)
+	if c.tc.DelayType == DelayBronstein {
+		c.remaining[color] += min(elapsed, c.tc.Delay)
+	}
+	c.remaining[color] += c.tc.Increment
+}
+
+// handOver charges the running side for the time used so far, without any
+// increment or delay, and runs the clock of a side from now on. It is used
+// when a takeback changes whose turn it is. It returns false without changing
+// anything when the running side has already run out of time.
+func (c *clock) handOver(color engine.Color, now time.Time) bool {
+	if c.flagged(now) {
+		return false
+	}
+	c.remaining[c.running] -= c.used(now)
+	c.start(color, now)
+	return true
+}
+
+// expired is the channel of the flag timer, or nil while the clock is
+// stopped so the room never receives from it
+func (c *clock) expired() <-chan time.Time {
+	if c == nil || c.running == engine.NoColor {
+		return nil
+	}
+	return c.timer.C
+}
+
+func (c *clock) payload(now time.Time) *ClockPayload {
+	p := &ClockPayload{
+		WhiteMs:     max(0, c.timeLeft(engine.White, now)).Milliseconds(),
+		BlackMs:     max(0, c.timeLeft(engine.Black, now)).Milliseconds(),
+		TimeControl: c.tc.payload(),
+	}
+	switch c.running {
+	case engine.White:
+		p.Running = "white"
+	case engine.Black:
+		p.Running = "black"
+	}
+	return p
+}
+
+// startClock starts White's clock when a timed game begins
+func (r *Room) startClock() {
+	if r.TimeControl == nil {
+		return
+	}
+	r.clock = newClock(*r.TimeControl)
+	r.clock.start(r.Game.Turn, time.Now())
+}
+
+// handleFlag ends the game of a side whose time ran out. It loses unless the
+// opponent could not possibly checkmate.
+func (r *Room) handleFlag() {
+	if r.GameState != "in_progress" || !r.clock.flagged(time.Now()) {
+		return
+	}
+	flagged := r.clock.running
+	winner := flagged.Opponent()
+	if !engine.HasMatingMaterial(r.Game, winner) {
+		r.endGame(models.ResultDraw, "timeout_vs_insufficient_material")
+		return
+	}
+	r.endGame(winFor(winner), "timeout")
+}
+
+// handleSetTimeControl lets the host choose the time control of the next game
+func (r *Room) handleSetTimeControl(sender *Client, tcPayload *TimeControlPayload) {
+	if sender != r.Host {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only the host can set the time control.")
+		return
+	}
+
+	if tcPayload.BaseMs == 0 {
+		r.TimeControl = nil
+	} else {
+		tc, err := newTimeControl(*tcPayload)
+		if err != nil {
+			r.sendErrorMessage(sender, ErrCodeInvalidPayload, "Invalid time control: "+err.Error())
+			return
+		}
+		r.TimeControl = &tc
+	}
+	log.Printf("Time control of room %s set to %+v", r.ID, r.TimeControl)
+	r.broadcastLobbyState()
+}
@@ -0,0 +1,226 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/bot"
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// Sources of engine_info messages
+const (
+	engineSourceBot      = "bot"
+	engineSourceAnalysis = "analysis"
+)
+
+// EngineInfoPayload reports the progress of a search, sent as "engine_info"
+// after every completed depth while the bot thinks or an analysis runs
+type EngineInfoPayload struct {
+	Source  string    `json:"source"` // "bot" or "analysis"
+	Depth   int       `json:"depth"`
+	MultiPV int       `json:"multi_pv"`
+	Score   bot.Score `json:"score"` // From White's point of view
+	PV      []string  `json:"pv"`    // SAN
+	PVUCI   []string  `json:"pv_uci"`
+	Nodes   int64     `json:"nodes"`
+	NPS     int64     `json:"nps"`
+	TimeMs  int64     `json:"time_ms"`
+}
+
+// AnalyzePayload is sent by a client to analyze a position. An empty FEN
+// analyzes the current position of the room.
+type AnalyzePayload struct {
+	FEN     string `json:"fen,omitempty"`
+	Depth   int    `json:"depth"`
+	TimeMs  int    `json:"time_ms"`
+	MultiPV int    `json:"multi_pv"`
+}
+
+// AnalysisCompletePayload is sent as "analysis_complete" when an analysis ends
+type AnalysisCompletePayload struct {
+	FEN      string `json:"fen"`
+	Depth    int    `json:"depth"`
+	BestMove string `json:"best_move,omitempty"` // Coordinate notation
+	Stopped  bool   `json:"stopped"`
+}
+
+// engineUpdate is a message from a search goroutine to the room. Messages
+// without a recipient report the bot's search and go to every client of the
+// room, except to its opponent during the game unless the bot was added to
+// show its thinking.
+type engineUpdate struct {
+	recipient *Client
+	message   Message
+
+	// analysisDone is set on the last message of the analysis with this stop
+	// channel
+	analysisDone chan struct{}
+}
+
+func newEngineInfoPayload(source string, info bot.SearchInfo) EngineInfoPayload {
+	return EngineInfoPayload{
+		Source:  source,
+		Depth:   info.Depth,
+		MultiPV: info.MultiPV,
+		Score:   info.Score,
+		PV:      info.PV,
+		PVUCI:   info.PVUCI,
+		Nodes:   info.Nodes,
+		NPS:     info.NPS,
+		TimeMs:  info.TimeMs,
+	}
+}
+
+// postEngineInfo queues search progress for the room. Progress is dropped
+// rather than slowing the search down when the room falls behind.
+func (r *Room) postEngineInfo(recipient *Client, source string, info bot.SearchInfo) {
+	update := &engineUpdate{
+		recipient: recipient,
+		message:   Message{Action: "engine_info", Payload: newEngineInfoPayload(source, info)},
+	}
+	select {
+	case r.EngineUpdates <- update:
+	default:
+	}
+}
+
+// handleEngineUpdate delivers a message of a search goroutine. Once the room
+// is closed the Send channels of its clients are closed too, so everything
+// is dropped.
+func (r *Room) handleEngineUpdate(update *engineUpdate) {
+	if r.closed {
+		return
+	}
+	if update.analysisDone != nil && r.analyses[update.recipient] == update.analysisDone {
+		delete(r.analyses, update.recipient)
+	}
+
+	clients := r.getAllClients()
+	messageBytes, _ := json.Marshal(update.message)
+	if update.recipient == nil {
+		hidden := r.GameState == "in_progress" && !r.botShowsThinking()
+		for client := range clients {
+			if hidden && r.isPlayer(client) {
+				continue
+			}
+			client.Send <- messageBytes
+		}
+	} else if clients[update.recipient] {
+		update.recipient.Send <- messageBytes
+	}
+}
+
+// handleAnalyze starts an analysis for a client, replacing one it already
+// runs. Players may not analyze their own game while it is being played.
+func (r *Room) handleAnalyze(sender *Client, req *AnalyzePayload) {
+	if sender.IsBot {
+		return
+	}
+	if r.GameState == "in_progress" && sender.PlayerColor != engine.NoColor {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Players cannot analyze while their game is in progress.")
+		return
+	}
+
+	pos := r.Game
+	if req.FEN != "" {
+		var err error
+		if pos, err = engine.ParseFEN(req.FEN); err != nil {
+			r.sendErrorMessage(sender, ErrCodeInvalidPayload, "Invalid FEN: "+err.Error())
+			return
+		}
+	}
+	opts, err := bot.NewAnalysisOptions(req.Depth, time.Duration(req.TimeMs)*time.Millisecond, req.MultiPV)
+	if err != nil {
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, err.Error())
+		return
+	}
+
+	// The slot of an analysis being replaced is given back once its search
+	// has stopped
+	r.stopAnalysis(sender)
+	done, err := bot.StartAnalysis()
+	if err != nil {
+		r.sendErrorMessage(sender, ErrCodeRateLimited, err.Error())
+		return
+	}
+	stop := make(chan struct{})
+	r.analyses[sender] = stop
+
+	opts.Stop = stop
+	opts.Info = func(info bot.SearchInfo) {
+		r.postEngineInfo(sender, engineSourceAnalysis, info)
+	}
+	go func() {
+		result := bot.AnalyzePosition(pos, opts)
+		done()
+
+		complete := AnalysisCompletePayload{FEN: result.FEN, Depth: result.Depth}
+		if len(result.Lines) > 0 && len(result.Lines[0].Moves) > 0 {
+			complete.BestMove = result.Lines[0].Moves[0].String()
+		}
+		select {
+		case <-stop:
+			complete.Stopped = true
+		default:
+		}
+		r.EngineUpdates <- &engineUpdate{
+			recipient:    sender,
+			message:      Message{Action: "analysis_complete", Payload: complete},
+			analysisDone: stop,
+		}
+	}()
+}
+
+// handleStopSearch stops the sender's analysis or, when it is the turn of a
+// computer opponent that shows its thinking, makes the bot play the best move
+// found so far
+func (r *Room) handleStopSearch(sender *Client) {
+	if _, ok := r.analyses[sender]; ok {
+		r.stopAnalysis(sender)
+		return
+	}
+
+	if r.GameState == "in_progress" && r.isPlayer(sender) {
+		if opponent := r.Players[sender.PlayerColor.Opponent()]; opponent != nil && opponent.IsBot {
+			if !opponent.ShowThinking {
+				r.sendErrorMessage(sender, ErrCodeForbidden, "The computer was added without showing its thinking, its search cannot be stopped.")
+				return
+			}
+			if r.Game.Turn == opponent.PlayerColor {
+				select {
+				case opponent.stopSearch <- struct{}{}:
+				default:
+				}
+				return
+			}
+		}
+	}
+	r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no search to stop.")
+}
+
+// botShowsThinking reports whether the room's bot, if any, streams its search
+// to its opponent
+func (r *Room) botShowsThinking() bool {
+	for _, p := range r.Players {
+		if p != nil && p.IsBot {
+			return p.ShowThinking
+		}
+	}
+	return false
+}
+
+// stopAnalysis stops the analysis of a client, if it runs one
+func (r *Room) stopAnalysis(client *Client) {
+	if stop, ok := r.analyses[client]; ok {
+		close(stop)
+		delete(r.analyses, client)
+	}
+}
+
+// stopAllAnalyses stops every analysis when the room closes
+func (r *Room) stopAllAnalyses() {
+	for client := range r.analyses {
+		r.stopAnalysis(client)
+	}
+}
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/TLeTu/Chess-Media/server/models"
+)
+
+// DrawOfferPayload is broadcast as "draw_offer" when a player offers a draw
+// and as "draw_declined" when the offer is declined or cancelled by a move
+type DrawOfferPayload struct {
+	Color string `json:"color"` // The player who made the offer
+}
+
+// colorName is the name of a color in payloads
+func colorName(color engine.Color) string {
+	switch color {
+	case engine.White:
+		return "white"
+	case engine.Black:
+		return "black"
+	}
+	return ""
+}
+
+// winFor is the result of a game won by a color
+func winFor(color engine.Color) string {
+	if color == engine.White {
+		return models.ResultWhiteWins
+	}
+	return models.ResultBlackWins
+}
+
+// isPlayer reports whether a client plays the game in progress
+func (r *Room) isPlayer(client *Client) bool {
+	color := client.PlayerColor
+	return (color == engine.White || color == engine.Black) && r.Players[color] == client
+}
+
+// isMember reports whether a client is seated in the room or watching it
+func (r *Room) isMember(client *Client) bool {
+	if r.Spectators[client] {
+		return true
+	}
+	for _, p := range r.Players {
+		if p == client {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Room) broadcastMessage(message Message) {
+	messageBytes, _ := json.Marshal(message)
+	for client := range r.getAllClients() {
+		client.Send <- messageBytes
+	}
+}
+
+func (r *Room) sendMessage(client *Client, message Message) {
+	messageBytes, _ := json.Marshal(message)
+	client.Send <- messageBytes
+}
+
+// handleResign ends the game as a loss for the sender
+func (r *Room) handleResign(sender *Client) {
+	if !r.isPlayer(sender) {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can resign.")
+		return
+	}
+	r.endGame(winFor(sender.PlayerColor.Opponent()), "resignation")
+}
+
+// handleOfferDraw offers the opponent a draw. Offering a draw while the
+// opponent's offer is pending accepts it.
+func (r *Room) handleOfferDraw(sender *Client) {
+	if !r.isPlayer(sender) {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can offer a draw.")
+		return
+	}
+	switch r.drawOffer {
+	case sender.PlayerColor:
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "You have already offered a draw.")
+		return
+	case sender.PlayerColor.Opponent():
+		r.endGame(models.ResultDraw, "agreement")
+		return
+	}
+
+	r.drawOffer = sender.PlayerColor
+	r.broadcastMessage(Message{Action: "draw_offer", Payload: DrawOfferPayload{Color: colorName(r.drawOffer)}})
+}
+
+// handleAcceptDraw ends the game as a draw if the opponent offered one
+func (r *Room) handleAcceptDraw(sender *Client) {
+	if !r.isPlayer(sender) || r.drawOffer != sender.PlayerColor.Opponent() {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no draw offer to accept.")
+		return
+	}
+	r.endGame(models.ResultDraw, "agreement")
+}
+
+// handleDeclineDraw turns down the opponent's draw offer
+func (r *Room) handleDeclineDraw(sender *Client) {
+	if !r.isPlayer(sender) || r.drawOffer != sender.PlayerColor.Opponent() {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no draw offer to decline.")
+		return
+	}
+	r.cancelDrawOffer()
+}
+
+// cancelDrawOffer withdraws the pending draw offer, if any
+func (r *Room) cancelDrawOffer() {
+	if r.drawOffer == engine.NoColor {
+		return
+	}
+	payload := DrawOfferPayload{Color: colorName(r.drawOffer)}
+	r.drawOffer = engine.NoColor
+	r.broadcastMessage(Message{Action: "draw_declined", Payload: payload})
+}
+
+// handleAbort cancels a game before both players made their first move. An
+// aborted game is not recorded and does not change ratings.
+func (r *Room) handleAbort(sender *Client) {
+	if !r.isPlayer(sender) {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can abort the game.")
+		return
+	}
+	if len(r.Moves) >= 2 {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "The game can only be aborted before both players have moved.")
+		return
+	}
+
+	log.Printf("Game %s aborted by %s", r.ID, sender.PlayerColor)
+	r.finish()
+	r.concludeGame(resultAborted, "aborted")
+}
+
+// finish stops everything that still runs once the game is over. Moves a bot
+// is still sending are ignored from now on.
+func (r *Room) finish() {
+	r.GameState = "finished"
+	r.stopAllAnalyses()
+	r.stopGraceTimers()
+	if r.clock != nil {
+		r.clock.stop()
+	}
+	for _, p := range r.Players {
+		if p != nil && p.IsBot {
+			select {
+			case p.stopSearch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// closeRoom tells every client why the room closes, disconnects them and
+// removes the room from the hub
+func (r *Room) closeRoom(reason string) {
+	r.saveChat()
+	r.broadcastMessage(Message{Action: "room_closed", Payload: RoomClosedPayload{Reason: reason}})
+	for _, p := range r.Players {
+		if p != nil {
+			close(p.Send)
+		}
+	}
+	for s := range r.Spectators {
+		close(s.Send)
+	}
+	r.closed = true
+	r.Hub.deleteRoom(r.ID)
+}
@@ -53,6 +53,13 @@ func ServeWs(hub *Hub, c *gin.Context) {
 		return
 	}
 
+	version, err := handshake(conn)
+	if err != nil {
+		log.Printf("Handshake with user %d for room %s failed: %v", user.ID, roomID, err)
+		conn.Close()
+		return
+	}
+
 	client := &Client{
 		Hub:     hub,
 		UserID:  user.ID,
@@ -61,6 +68,8 @@ func ServeWs(hub *Hub, c *gin.Context) {
 		RoomID:  roomID,
 		UserELO: user.ELO,
 		User:    &user,
+
+		ProtocolVersion: version,
 	}
 
 	hub.Register <- client
@@ -1,12 +1,19 @@
 package ws
 
-import "log"
+import (
+	"log"
+	"sync"
+)
 
 type Hub struct {
+	// Rooms is also written by the ranked queue and the lobby when they
+	// create a room, so it is guarded by roomsMu
 	Rooms       map[string]*Room
+	roomsMu     sync.Mutex
 	Register    chan *Client
 	Unregister  chan *Client
 	RankedQueue *RankedQueue
+	Lobby       *Lobby
 }
 
 func NewHub() *Hub {
@@ -16,6 +23,7 @@ func NewHub() *Hub {
 		Unregister: make(chan *Client),
 	}
 	hub.RankedQueue = NewRankedQueue(hub)
+	hub.Lobby = NewLobby(hub)
 	return hub
 }
 
@@ -28,28 +36,54 @@ func (h *Hub) Run() {
 			if client.RoomID == "ranked" {
 				log.Printf("Client %d added to ranked queue", client.UserID)
 				client.Hub.RankedQueue.AddPlayer(client, client.UserELO)
+			} else if client.RoomID == lobbyRoomID {
+				h.Lobby.AddSubscriber(client)
 			} else {
-				if _, ok := h.Rooms[client.RoomID]; !ok {
+				h.roomsMu.Lock()
+				room, ok := h.Rooms[client.RoomID]
+				if !ok {
 					// Create a new unranked room if it doesn't exist
-					room := NewRoom(client.RoomID, h, false)
+					room = NewRoom(client.RoomID, h, false)
 					h.Rooms[client.RoomID] = room
 					go room.Run()
 					log.Printf("New unranked room created: %s", client.RoomID)
 				}
-				h.Rooms[client.RoomID].Register <- client
+				h.roomsMu.Unlock()
+				room.Register <- client
 			}
 		case client := <-h.Unregister:
 			if client.RoomID == "ranked" {
 				h.RankedQueue.RemovePlayer(client)
-			} else if room, ok := h.Rooms[client.RoomID]; ok {
+			} else if client.RoomID == lobbyRoomID {
+				h.Lobby.RemoveSubscriber(client)
+			} else if room, ok := h.room(client.RoomID); ok {
 				room.Unregister <- client
 			}
 		}
 	}
 }
 
+// room looks up a room by its ID
+func (h *Hub) room(roomID string) (*Room, bool) {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+	room, ok := h.Rooms[roomID]
+	return room, ok
+}
+
+// addRoom starts a room created outside the hub, by the ranked queue or the
+// lobby
+func (h *Hub) addRoom(room *Room) {
+	h.roomsMu.Lock()
+	h.Rooms[room.ID] = room
+	h.roomsMu.Unlock()
+	go room.Run()
+}
+
 // Called from a Room when it becomes empty
 func (h *Hub) deleteRoom(roomID string) {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
 	if _, ok := h.Rooms[roomID]; ok {
 		delete(h.Rooms, roomID)
 		log.Printf("Room %s deleted", roomID)
@@ -0,0 +1,332 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/gin-gonic/gin"
+)
+
+// The lobby
+//
+// Clients connected to the room "lobby" follow the open seeks: they get the
+// list as "lobby_seeks" when they connect, then "seek_added" and
+// "seek_removed" as seeks come and go. They post seeks with "create_seek",
+// withdraw them with "cancel_seek" and take one with "accept_seek", which
+// creates a room with a seat reserved for both players and sends them
+// "seek_accepted" with the room to join. A user's seeks are withdrawn when
+// their lobby connection closes.
+
+// lobbyRoomID is the room ID of lobby connections
+const lobbyRoomID = "lobby"
+
+// maxSeeksPerUser is how many open seeks a user may have at once
+const maxSeeksPerUser = 3
+
+// VariantStandard is the only variant played for now
+const VariantStandard = "standard"
+
+// SeekPayload is sent by a client as "create_seek" and describes a seek in
+// "lobby_seeks" and "seek_added"
+type SeekPayload struct {
+	ID          string              `json:"id,omitempty"`      // Set by the server
+	UserID      uint                `json:"user_id,omitempty"` // Set by the server
+	ELO         int                 `json:"elo,omitempty"`     // Set by the server
+	TimeControl *TimeControlPayload `json:"time_control,omitempty"`
+	Variant     string              `json:"variant,omitempty"` // "standard", the default
+	Rated       bool                `json:"rated"`
+	MinRating   int                 `json:"min_rating,omitempty"` // 0 for no limit
+	MaxRating   int                 `json:"max_rating,omitempty"` // 0 for no limit
+	Color       string              `json:"color,omitempty"`      // Color of the creator: "white", "black" or "random", the default
+	CreatedAt   int64               `json:"created_at,omitempty"` // Unix milliseconds, set by the server
+}
+
+// SeekIDPayload is sent by a client as "cancel_seek" or "accept_seek" and
+// broadcast as "seek_removed"
+type SeekIDPayload struct {
+	SeekID string `json:"seek_id"`
+}
+
+// LobbySeeksPayload is the list of open seeks, sent as "lobby_seeks" and by
+// GET /api/lobby
+type LobbySeeksPayload struct {
+	Seeks []SeekPayload `json:"seeks"`
+}
+
+// SeekAcceptedPayload is sent to both players as "seek_accepted" once a seek
+// was accepted
+type SeekAcceptedPayload struct {
+	SeekID string `json:"seek_id"`
+	RoomID string `json:"room_id"`
+	Color  string `json:"color"`
+}
+
+// Lobby keeps the open seeks and the clients following them
+type Lobby struct {
+	mu          sync.Mutex
+	seeks       map[string]*SeekPayload // map[seekID]*SeekPayload
+	subscribers map[*Client]bool
+	hub         *Hub
+}
+
+// NewLobby creates an empty lobby
+func NewLobby(h *Hub) *Lobby {
+	return &Lobby{
+		seeks:       make(map[string]*SeekPayload),
+		subscribers: make(map[*Client]bool),
+		hub:         h,
+	}
+}
+
+// AddSubscriber starts sending the seeks to a lobby client
+func (l *Lobby) AddSubscriber(client *Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.subscribers[client] = true
+	log.Printf("Client %d joined the lobby. Subscribers: %d", client.UserID, len(l.subscribers))
+	l.send(client, Message{Action: "lobby_seeks", Payload: LobbySeeksPayload{Seeks: l.list()}})
+}
+
+// RemoveSubscriber stops sending the seeks to a lobby client and withdraws
+// the seeks its user posted
+func (l *Lobby) RemoveSubscriber(client *Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.subscribers[client] {
+		return
+	}
+	delete(l.subscribers, client)
+	close(client.Send)
+	l.removeSeeksOf(client.UserID)
+	log.Printf("Client %d left the lobby. Subscribers: %d", client.UserID, len(l.subscribers))
+}
+
+// Seeks returns the open seeks, oldest first
+func (l *Lobby) Seeks() []SeekPayload {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.list()
+}
+
+// list returns the open seeks, oldest first. The lock must be held.
+func (l *Lobby) list() []SeekPayload {
+	seeks := make([]SeekPayload, 0, len(l.seeks))
+	for _, seek := range l.seeks {
+		seeks = append(seeks, *seek)
+	}
+	sort.Slice(seeks, func(i, j int) bool {
+		if seeks[i].CreatedAt != seeks[j].CreatedAt {
+			return seeks[i].CreatedAt < seeks[j].CreatedAt
+		}
+		return seeks[i].ID < seeks[j].ID
+	})
+	return seeks
+}
+
+// handleMessage carries out a request of a lobby client, answering it like a
+// room does
+func (l *Lobby) handleMessage(clientMessage *ClientMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sender := clientMessage.Client
+	message := clientMessage.Message
+	if !l.subscribers[sender] {
+		return
+	}
+
+	var err *protocolError
+	if clientMessage.Error != nil {
+		err = clientMessage.Error
+	} else {
+		switch message.Action {
+		case "create_seek":
+			err = l.createSeek(sender, message.Payload.(*SeekPayload))
+		case "cancel_seek":
+			err = l.cancelSeek(sender, message.Payload.(*SeekIDPayload))
+		case "accept_seek":
+			err = l.acceptSeek(sender, message.Payload.(*SeekIDPayload))
+		default:
+			err = &protocolError{ErrCodeInvalidState, fmt.Sprintf("Action '%s' is not allowed in the lobby.", message.Action)}
+		}
+	}
+
+	if err != nil {
+		l.send(sender, Message{Action: "error", RequestID: message.RequestID, Payload: ErrorPayload{Code: err.code, Message: err.message}})
+	} else if message.RequestID != "" {
+		l.send(sender, Message{Action: "ack", RequestID: message.RequestID, Payload: AckPayload{Action: message.Action}})
+	}
+}
+
+// createSeek checks a new seek and posts it
+func (l *Lobby) createSeek(sender *Client, seek *SeekPayload) *protocolError {
+	if seek.Variant == "" {
+		seek.Variant = VariantStandard
+	}
+	if seek.Variant != VariantStandard {
+		return &protocolError{ErrCodeInvalidPayload, fmt.Sprintf("The variant '%s' is not supported.", seek.Variant)}
+	}
+	if seek.Color == "" {
+		seek.Color = "random"
+	}
+	if seek.Color != "white" && seek.Color != "black" && seek.Color != "random" {
+		return &protocolError{ErrCodeInvalidPayload, "The color must be white, black or random."}
+	}
+	if seek.MinRating < 0 || seek.MaxRating < 0 || (seek.MaxRating != 0 && seek.MaxRating < seek.MinRating) {
+		return &protocolError{ErrCodeInvalidPayload, "Invalid rating range."}
+	}
+	if seek.TimeControl != nil && seek.TimeControl.BaseMs == 0 {
+		seek.TimeControl = nil
+	}
+	if seek.TimeControl != nil {
+		if _, err := newTimeControl(*seek.TimeControl); err != nil {
+			return &protocolError{ErrCodeInvalidPayload, "Invalid time control: " + err.Error()}
+		}
+	}
+
+	count := 0
+	for _, other := range l.seeks {
+		if other.UserID == sender.UserID {
+			count++
+		}
+	}
+	if count >= maxSeeksPerUser {
+		return &protocolError{ErrCodeRateLimited, fmt.Sprintf("You can have at most %d open seeks.", maxSeeksPerUser)}
+	}
+
+	seek.ID = generateRoomID()
+	seek.UserID = sender.UserID
+	seek.ELO = sender.UserELO
+	seek.CreatedAt = time.Now().UnixMilli()
+	l.seeks[seek.ID] = seek
+
+	log.Printf("Client %d posted seek %s: %+v", sender.UserID, seek.ID, *seek)
+	l.broadcast(Message{Action: "seek_added", Payload: *seek})
+	return nil
+}
+
+// cancelSeek withdraws a seek of the sender
+func (l *Lobby) cancelSeek(sender *Client, target *SeekIDPayload) *protocolError {
+	seek, ok := l.seeks[target.SeekID]
+	if !ok {
+		return &protocolError{ErrCodeInvalidState, "This seek is not open anymore."}
+	}
+	if seek.UserID != sender.UserID {
+		return &protocolError{ErrCodeForbidden, "You can only cancel your own seeks."}
+	}
+	l.removeSeek(seek.ID)
+	return nil
+}
+
+// acceptSeek creates the room of an accepted seek with a seat reserved for
+// both players, and withdraws their other seeks
+func (l *Lobby) acceptSeek(sender *Client, target *SeekIDPayload) *protocolError {
+	seek, ok := l.seeks[target.SeekID]
+	if !ok {
+		return &protocolError{ErrCodeInvalidState, "This seek is not open anymore."}
+	}
+	if seek.UserID == sender.UserID {
+		return &protocolError{ErrCodeForbidden, "You cannot accept your own seek."}
+	}
+	if sender.UserELO < seek.MinRating || (seek.MaxRating != 0 && sender.UserELO > seek.MaxRating) {
+		return &protocolError{ErrCodeForbidden, "Your rating is outside the range of this seek."}
+	}
+	var creator *Client
+	for client := range l.subscribers {
+		if client.UserID == seek.UserID {
+			creator = client
+			break
+		}
+	}
+	if creator == nil {
+		l.removeSeek(seek.ID)
+		return &protocolError{ErrCodeInvalidState, "This seek is not open anymore."}
+	}
+
+	creatorColor := engine.White
+	switch seek.Color {
+	case "black":
+		creatorColor = engine.Black
+	case "random":
+		if rand.Intn(2) == 0 {
+			creatorColor = engine.Black
+		}
+	}
+
+	room := NewRoom(generateRoomID(), l.hub, seek.Rated)
+	room.ReservedSeats = true
+	if seek.TimeControl != nil {
+		tc, _ := newTimeControl(*seek.TimeControl)
+		room.TimeControl = &tc
+	}
+	room.PendingPlayers[seek.UserID] = creatorColor
+	room.PendingPlayers[sender.UserID] = creatorColor.Opponent()
+	l.hub.addRoom(room)
+	log.Printf("Seek %s of client %d accepted by client %d in room %s", seek.ID, seek.UserID, sender.UserID, room.ID)
+
+	for client := range l.subscribers {
+		switch client.UserID {
+		case seek.UserID:
+			l.send(client, Message{Action: "seek_accepted", Payload: SeekAcceptedPayload{SeekID: seek.ID, RoomID: room.ID, Color: colorName(creatorColor)}})
+		case sender.UserID:
+			l.send(client, Message{Action: "seek_accepted", Payload: SeekAcceptedPayload{SeekID: seek.ID, RoomID: room.ID, Color: colorName(creatorColor.Opponent())}})
+		}
+	}
+	l.removeSeeksOf(seek.UserID)
+	l.removeSeeksOf(sender.UserID)
+	return nil
+}
+
+// removeSeeksOf withdraws every seek of a user. The lock must be held.
+func (l *Lobby) removeSeeksOf(userID uint) {
+	for id, seek := range l.seeks {
+		if seek.UserID == userID {
+			l.removeSeek(id)
+		}
+	}
+}
+
+// removeSeek withdraws a seek and tells the lobby. The lock must be held.
+func (l *Lobby) removeSeek(seekID string) {
+	delete(l.seeks, seekID)
+	l.broadcast(Message{Action: "seek_removed", Payload: SeekIDPayload{SeekID: seekID}})
+}
+
+// send sends a message to a lobby client. The lock must be held.
+func (l *Lobby) send(client *Client, message Message) {
+	messageBytes, _ := json.Marshal(message)
+	l.deliver(client, messageBytes)
+}
+
+// broadcast sends a message to every lobby client. The lock must be held.
+func (l *Lobby) broadcast(message Message) {
+	messageBytes, _ := json.Marshal(message)
+	for client := range l.subscribers {
+		l.deliver(client, messageBytes)
+	}
+}
+
+// deliver queues a message for a lobby client without waiting. The hub takes
+// the lobby lock to add and remove subscribers, so a client that does not
+// keep up must not hold it; it misses the message instead.
+func (l *Lobby) deliver(client *Client, messageBytes []byte) {
+	select {
+	case client.Send <- messageBytes:
+	default:
+		log.Printf("Lobby client %d is not keeping up, dropping a message", client.UserID)
+	}
+}
+
+// LobbyHandler lists the open seeks
+func LobbyHandler(hub *Hub, c *gin.Context) {
+	c.JSON(http.StatusOK, LobbySeeksPayload{Seeks: hub.Lobby.Seeks()})
+}
@@ -1,9 +1,12 @@
 package ws
 
-// Message defines the structure for messages sent over WebSocket
+// Message defines the structure for messages sent over WebSocket, see
+// protocol.go. The payloads of client messages are decoded into the type
+// their action takes.
 type Message struct {
-	Action  string      `json:"action"`
-	Payload interface{} `json:"payload"`
+	Action    string      `json:"action"`
+	RequestID string      `json:"request_id,omitempty"` // Chosen by the client, echoed in the reply
+	Payload   interface{} `json:"payload"`
 }
 
 // MovePayLoad defines the payload for a "move" action
@@ -15,12 +18,45 @@ type MovePayload struct {
 
 // GameStatePayload defines the payload for a "game_state" update
 type GameStatePayload struct {
-	FEN        string `json:"fen"`
-	GameStatus string `json:"game_status"`
+	FEN        string           `json:"fen"`
+	GameStatus string           `json:"game_status"`
+	Moves      []string         `json:"moves"`               // Moves played so far in UCI notation
+	SAN        []string         `json:"san"`                 // The same moves in SAN
+	LastMove   *LastMovePayload `json:"last_move,omitempty"` // Empty before the first move
+	InCheck    bool             `json:"in_check"`            // The side to move is in check
+	Captured   CapturedPayload  `json:"captured"`
+	Ply        int              `json:"ply"`                        // Number of moves played by both sides
+	Version    uint64           `json:"version"`                    // Increases with every game_state of the room
+	Clock      *ClockPayload    `json:"clock,omitempty"`            // Only in timed games
+	DrawOffer  string           `json:"draw_offer,omitempty"`       // Color of the player offering a draw
+	Takeback   string           `json:"takeback_request,omitempty"` // Color of the player asking for a takeback
+
+	// Set once the game is over, see rematch.go
+	Result      string `json:"result,omitempty"`
+	Termination string `json:"termination,omitempty"`
+	Rematch     string `json:"rematch_offer,omitempty"` // Color of the player offering a rematch
+}
+
+// LastMovePayload is the last move played in a "game_state"
+type LastMovePayload struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Promotion string `json:"promotion,omitempty"`
+	UCI       string `json:"uci"`
+	SAN       string `json:"san"`
+}
+
+// CapturedPayload lists the pieces each side has captured, in the order they
+// were taken, as FEN letters: White captures lowercase pieces and Black
+// uppercase ones.
+type CapturedPayload struct {
+	White []string `json:"white"`
+	Black []string `json:"black"`
 }
 
 // ErrorPayload defines the payload for an "error" message
 type ErrorPayload struct {
+	Code    string `json:"code"` // One of the ErrCode constants
 	Message string `json:"message"`
 }
 
@@ -43,4 +79,8 @@ type LobbyStatePayload struct {
 	GameState   string `json:"game_state"`
 	PlayerCount int    `json:"player_count"`
 	GameType    string `json:"game_type"` // "ranked" or "unranked"
+	GuestIsBot  bool   `json:"guest_is_bot"`
+	BotLevel    int    `json:"bot_level,omitempty"`
+
+	TimeControl *TimeControlPayload `json:"time_control,omitempty"` // Untimed if empty
 }
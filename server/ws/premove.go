@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// PremovePayload is sent to a player as "premove_queued" when their premove
+// is accepted, as "premove_cancelled" when it is withdrawn and as
+// "premove_rejected" when it turned out to be illegal once their turn came
+type PremovePayload struct {
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Promotion string `json:"promotion,omitempty"`
+	Reason    string `json:"reason,omitempty"` // Only in "premove_rejected"
+}
+
+// premove is a move queued by the player who is not to move. It is played as
+// soon as the opponent's move lands, if it is legal then.
+type premove struct {
+	color   engine.Color
+	payload PremovePayload
+}
+
+// parseSquare reads a square such as "e4"
+func parseSquare(s string) (engine.Square, bool) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return 0, false
+	}
+	return engine.Square(int(s[1]-'1')*8 + int(s[0]-'a')), true
+}
+
+// handlePremove queues a move of the sender for when it is their turn,
+// replacing the premove they queued before. A premove sent on the sender's
+// turn, because the opponent's move landed meanwhile, is played at once.
+func (r *Room) handlePremove(sender *Client, movePayload *MovePayload) {
+	if !r.isPlayer(sender) {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can premove.")
+		return
+	}
+	if sender.PlayerColor == r.Game.Turn {
+		r.handleMove(sender, movePayload)
+		return
+	}
+
+	from, okFrom := parseSquare(movePayload.From)
+	_, okTo := parseSquare(movePayload.To)
+	if !okFrom || !okTo || movePayload.From == movePayload.To {
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, "Invalid premove squares.")
+		return
+	}
+	// The position will change before the premove is played, so only the
+	// piece to move is checked now
+	if piece := r.Game.Board[from]; piece == engine.Empty || piece.Color() != sender.PlayerColor {
+		r.sendErrorMessage(sender, ErrCodeIllegalMove, "You have no piece on "+movePayload.From+".")
+		return
+	}
+
+	r.premove = &premove{color: sender.PlayerColor, payload: PremovePayload{
+		From:      movePayload.From,
+		To:        movePayload.To,
+		Promotion: movePayload.Promotion,
+	}}
+	r.sendMessage(sender, Message{Action: "premove_queued", Payload: r.premove.payload})
+}
+
+// handleCancelPremove withdraws the sender's premove
+func (r *Room) handleCancelPremove(sender *Client) {
+	if r.premove == nil || r.premove.color != sender.PlayerColor {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "You have no premove to cancel.")
+		return
+	}
+	r.premove = nil
+	r.sendMessage(sender, Message{Action: "premove_cancelled", Payload: PremovePayload{}})
+}
+
+// playPremove plays the premove of the side to move, if any, or tells its
+// player why it could not be played
+func (r *Room) playPremove() {
+	pm := r.premove
+	if pm == nil || pm.color != r.Game.Turn || r.GameState != "in_progress" {
+		return
+	}
+	r.premove = nil
+
+	moveStr := pm.payload.From + pm.payload.To + pm.payload.Promotion
+	move, err := engine.ParseMove(r.Game, moveStr)
+	if err != nil {
+		log.Printf("Premove %s of %s in room %s discarded: %v", moveStr, pm.color, r.ID, err)
+		if p := r.Players[pm.color]; p != nil && !p.disconnected {
+			rejected := pm.payload
+			rejected.Reason = err.Error()
+			r.sendMessage(p, Message{Action: "premove_rejected", Payload: rejected})
+		}
+		return
+	}
+	r.playMove(move, true)
+}
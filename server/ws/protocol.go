@@ -0,0 +1,223 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The WebSocket protocol
+//
+// Every message in either direction is a JSON object
+//
+//	{"action": "move", "request_id": "42", "payload": {...}}
+//
+// The first message of a connection must be a "hello" from the client with
+// the protocol version it speaks. The server answers with its own "hello",
+// or with an "unsupported_version" error before closing the connection.
+//
+// Each client action has a payload type listed in clientPayloads; actions
+// without a payload take none. A request may carry a request_id chosen by the
+// client, which is then answered by an "ack" once the action was carried out
+// or by an "error" with the same request_id. Errors carry one of the error
+// codes below and a readable message.
+//
+// Version 1 is the first versioned protocol. Versions are bumped whenever an
+// action or payload changes in a way older clients cannot handle.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// handshakeTimeout is how long a new connection has to send its "hello"
+const handshakeTimeout = 10 * time.Second
+
+// Error codes of "error" messages
+const (
+	ErrCodeBadMessage         = "bad_message"         // Not a JSON message with an action
+	ErrCodeUnknownAction      = "unknown_action"      // No such client action
+	ErrCodeInvalidPayload     = "invalid_payload"     // The payload does not match the action or has invalid values
+	ErrCodeUnsupportedVersion = "unsupported_version" // The protocol version of the client is not supported
+	ErrCodeForbidden          = "forbidden"           // The sender may not do this, for example a guest starting the game
+	ErrCodeInvalidState       = "invalid_state"       // Not possible in the current state of the room or game
+	ErrCodeNotYourTurn        = "not_your_turn"
+	ErrCodeIllegalMove        = "illegal_move"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeMuted              = "muted"
+)
+
+// clientPayloads lists every action a client may send, with a constructor of
+// its payload type or nil if it takes none
+var clientPayloads = map[string]func() interface{}{
+	// Lobby of unranked rooms
+	"assign_color":     func() interface{} { return &AssignColorPayload{} },
+	"player_ready":     nil,
+	"start_game":       nil,
+	"add_bot":          func() interface{} { return &AddBotPayload{} },
+	"remove_bot":       nil,
+	"set_time_control": func() interface{} { return &TimeControlPayload{} },
+
+	// Game in progress
+	"move":             func() interface{} { return &MovePayload{} },
+	"premove":          func() interface{} { return &MovePayload{} },
+	"cancel_premove":   nil,
+	"resign":           nil,
+	"offer_draw":       nil,
+	"accept_draw":      nil,
+	"decline_draw":     nil,
+	"abort":            nil,
+	"request_takeback": nil,
+	"accept_takeback":  nil,
+	"decline_takeback": nil,
+	"claim_victory":    nil,
+
+	// Finished game
+	"offer_rematch":   nil,
+	"accept_rematch":  nil,
+	"decline_rematch": nil,
+
+	// Any state
+	"analyze":     func() interface{} { return &AnalyzePayload{} },
+	"stop_search": nil,
+	"chat":        func() interface{} { return &ChatPayload{} },
+	"mute":        func() interface{} { return &MutePayload{} },
+	"unmute":      func() interface{} { return &MutePayload{} },
+
+	// Lobby of open seeks, see lobby.go
+	"create_seek": func() interface{} { return &SeekPayload{} },
+	"cancel_seek": func() interface{} { return &SeekIDPayload{} },
+	"accept_seek": func() interface{} { return &SeekIDPayload{} },
+}
+
+// HelloPayload is the "hello" of both sides of a new connection
+type HelloPayload struct {
+	ProtocolVersion    int `json:"protocol_version"`
+	MinProtocolVersion int `json:"min_protocol_version,omitempty"` // Only sent by the server
+}
+
+// AckPayload is sent as "ack" once a request with a request_id was carried out
+type AckPayload struct {
+	Action string `json:"action"`
+}
+
+// RoomClosedPayload is sent as "room_closed" right before the server closes
+// the connections of a room
+type RoomClosedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// protocolError is an error to report to a client with its code
+type protocolError struct {
+	code    string
+	message string
+}
+
+func (e *protocolError) Error() string {
+	return e.message
+}
+
+// rawMessage is a client message whose payload is not decoded yet
+type rawMessage struct {
+	Action    string          `json:"action"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// decodeClientMessage decodes a client message and its payload into the type
+// the action takes. The returned message is never nil, so that errors can be
+// sent with its request ID.
+func decodeClientMessage(data []byte) (*Message, *protocolError) {
+	var raw rawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || raw.Action == "" {
+		return &Message{Action: raw.Action, RequestID: raw.RequestID}, &protocolError{ErrCodeBadMessage, "Messages must be JSON objects with an action."}
+	}
+	message := &Message{Action: raw.Action, RequestID: raw.RequestID}
+
+	newPayload, ok := clientPayloads[raw.Action]
+	if !ok {
+		return message, &protocolError{ErrCodeUnknownAction, fmt.Sprintf("Unknown action '%s'.", raw.Action)}
+	}
+	if newPayload == nil {
+		return message, nil
+	}
+
+	payload := newPayload()
+	if len(raw.Payload) > 0 && !bytes.Equal(raw.Payload, []byte("null")) {
+		decoder := json.NewDecoder(bytes.NewReader(raw.Payload))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(payload); err != nil {
+			return message, &protocolError{ErrCodeInvalidPayload, fmt.Sprintf("Invalid payload for '%s': %v", raw.Action, err)}
+		}
+	}
+	message.Payload = payload
+	return message, nil
+}
+
+// handshake waits for the "hello" of a new connection and answers it. The
+// connection must be closed if it fails.
+func handshake(conn *websocket.Conn) (int, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reply := func(message Message) {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		conn.WriteJSON(message)
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return 0, fmt.Errorf("no hello received: %w", err)
+	}
+	var raw rawMessage
+	var hello HelloPayload
+	if err := json.Unmarshal(data, &raw); err != nil || raw.Action != "hello" || json.Unmarshal(raw.Payload, &hello) != nil {
+		reply(Message{Action: "error", RequestID: raw.RequestID, Payload: ErrorPayload{
+			Code:    ErrCodeBadMessage,
+			Message: "The first message must be a hello with the protocol version.",
+		}})
+		return 0, errors.New("the first message was not a hello")
+	}
+	if hello.ProtocolVersion < MinProtocolVersion || hello.ProtocolVersion > ProtocolVersion {
+		reply(Message{Action: "error", RequestID: raw.RequestID, Payload: ErrorPayload{
+			Code:    ErrCodeUnsupportedVersion,
+			Message: fmt.Sprintf("Protocol version %d is not supported, the server speaks versions %d to %d.", hello.ProtocolVersion, MinProtocolVersion, ProtocolVersion),
+		}})
+		return 0, fmt.Errorf("unsupported protocol version %d", hello.ProtocolVersion)
+	}
+
+	reply(Message{Action: "hello", RequestID: raw.RequestID, Payload: HelloPayload{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+	}})
+	return hello.ProtocolVersion, nil
+}
+
+// handleClientMessage carries out a client request, answering it with an
+// "ack" if it has a request ID and did not fail. Messages of clients that
+// have left the room are dropped, their Send channel is already closed.
+func (r *Room) handleClientMessage(clientMessage *ClientMessage) {
+	sender := clientMessage.Client
+	if r.closed || !r.isMember(sender) {
+		return
+	}
+
+	r.request = clientMessage
+	r.requestFailed = false
+	defer func() { r.request = nil }()
+
+	if err := clientMessage.Error; err != nil {
+		r.sendErrorMessage(sender, err.code, err.message)
+		return
+	}
+	r.dispatch(sender, clientMessage.Message)
+
+	if requestID := clientMessage.Message.RequestID; requestID != "" && !r.requestFailed && !r.closed && r.isMember(sender) {
+		message := Message{Action: "ack", RequestID: requestID, Payload: AckPayload{Action: clientMessage.Message.Action}}
+		messageBytes, _ := json.Marshal(message)
+		sender.Send <- messageBytes
+	}
+}
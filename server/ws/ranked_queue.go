@@ -115,8 +115,7 @@ func (rq *RankedQueue) FindMatches() {
 
 				roomID := generateRoomID()
 				room := NewRoom(roomID, rq.hub, true)
-				rq.hub.Rooms[roomID] = room
-				go room.Run()
+				rq.hub.addRoom(room)
 
 				rq.assignPlayersToRankedRoom(room, player1.Client, player2.Client)
 
@@ -150,8 +149,8 @@ func (rq *RankedQueue) assignPlayersToRankedRoom(room *Room, client1, client2 *C
 		blackPlayerClient = client1
 	}
 
-	room.PendingRankedPlayers[whitePlayerClient.UserID] = engine.White
-	room.PendingRankedPlayers[blackPlayerClient.UserID] = engine.Black
+	room.PendingPlayers[whitePlayerClient.UserID] = engine.White
+	room.PendingPlayers[blackPlayerClient.UserID] = engine.Black
 
 	rq.sendMatchFound(whitePlayerClient, room.ID, "white")
 	rq.sendMatchFound(blackPlayerClient, room.ID, "black")
@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// reconnectGracePeriod is how long the seat of a player who lost their
+// connection during a game is held. Their clock keeps running meanwhile.
+var reconnectGracePeriod = 60 * time.Second
+
+// ConfigureReconnectFromEnv reads RECONNECT_GRACE_PERIOD, a duration such as
+// "90s". It must be called before any room is created.
+func ConfigureReconnectFromEnv() error {
+	if value := os.Getenv("RECONNECT_GRACE_PERIOD"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			return fmt.Errorf("invalid RECONNECT_GRACE_PERIOD: %s", value)
+		}
+		reconnectGracePeriod = grace
+	}
+	return nil
+}
+
+// ConnectionPayload is broadcast as "player_disconnected" when a player's
+// connection drops, as "player_reconnected" when they are back and as
+// "disconnect_timeout" once their grace period is over and the opponent may
+// claim the victory
+type ConnectionPayload struct {
+	Color   string `json:"color"`
+	GraceMs int64  `json:"grace_ms,omitempty"` // Only in "player_disconnected"
+}
+
+// disconnection is a held seat
+type disconnection struct {
+	color   engine.Color
+	timer   *time.Timer
+	expired bool
+}
+
+// holdSeat keeps the seat of a player whose connection dropped during a game.
+// The client stays in Players but is no longer sent anything; its Send
+// channel is closed with the room.
+func (r *Room) holdSeat(client *Client) {
+	client.disconnected = true
+	d := &disconnection{color: client.PlayerColor}
+	d.timer = time.AfterFunc(reconnectGracePeriod, func() { r.graceExpired <- d })
+	r.disconnections[d.color] = d
+
+	log.Printf("Player %d of room %s disconnected, holding the seat for %v", client.UserID, r.ID, reconnectGracePeriod)
+	r.broadcastMessage(Message{Action: "player_disconnected", Payload: ConnectionPayload{
+		Color:   colorName(d.color),
+		GraceMs: reconnectGracePeriod.Milliseconds(),
+	}})
+}
+
+// reattach seats a client in the game its user is playing, replacing a
+// connection that dropped or went stale, and resends the whole state. It
+// reports whether the client was a returning player.
+func (r *Room) reattach(client *Client) bool {
+	if r.GameState != "in_progress" {
+		return false
+	}
+	for _, color := range []engine.Color{engine.White, engine.Black} {
+		old := r.Players[color]
+		if old == nil || old.IsBot || old.UserID != client.UserID {
+			continue
+		}
+
+		if d, ok := r.disconnections[color]; ok {
+			d.timer.Stop()
+			delete(r.disconnections, color)
+		} else {
+			// The old connection has not noticed it is gone yet. Closing
+			// its Send channel closes it, and its unregistration is then
+			// ignored.
+			r.stopAnalysis(old)
+			close(old.Send)
+		}
+		old.disconnected = false
+
+		client.PlayerColor = color
+		r.Players[color] = client
+		if r.Host == old {
+			r.Host = client
+		}
+		if ready, ok := r.ReadyState[old]; ok {
+			delete(r.ReadyState, old)
+			r.ReadyState[client] = ready
+		}
+
+		log.Printf("Player %d reconnected to room %s", client.UserID, r.ID)
+		r.resync(client)
+		r.broadcastMessage(Message{Action: "player_reconnected", Payload: ConnectionPayload{Color: colorName(color)}})
+		return true
+	}
+	return false
+}
+
+// resync sends a returning player everything needed to resume the game
+func (r *Room) resync(client *Client) {
+	send := func(message Message) {
+		messageBytes, _ := json.Marshal(message)
+		client.Send <- messageBytes
+	}
+	send(Message{Action: "player_assigned", Payload: PlayerAssignmentPayload{Color: colorName(client.PlayerColor)}})
+	send(Message{Action: "game_state", Payload: r.gameStatePayload()})
+
+	// The opponent may have left for good while this player was away
+	if d, ok := r.disconnections[client.PlayerColor.Opponent()]; ok {
+		action := "player_disconnected"
+		if d.expired {
+			action = "disconnect_timeout"
+		}
+		send(Message{Action: action, Payload: ConnectionPayload{Color: colorName(d.color)}})
+	}
+}
+
+// handleGraceExpired lets the opponent of a player who did not come back
+// claim the victory. A computer opponent claims it at once, and a game both
+// players left is aborted.
+func (r *Room) handleGraceExpired(d *disconnection) {
+	if r.GameState != "in_progress" || r.disconnections[d.color] != d {
+		return
+	}
+	d.expired = true
+	log.Printf("Grace period of %s in room %s expired", d.color, r.ID)
+
+	opponent := r.Players[d.color.Opponent()]
+	switch other, ok := r.disconnections[d.color.Opponent()]; {
+	case opponent != nil && opponent.IsBot:
+		r.endGame(winFor(d.color.Opponent()), "abandonment")
+	case ok && other.expired:
+		log.Printf("Both players left room %s, aborting the game", r.ID)
+		r.finish()
+		r.closeRoom("Both players left. The game was aborted.")
+	default:
+		r.broadcastMessage(Message{Action: "disconnect_timeout", Payload: ConnectionPayload{Color: colorName(d.color)}})
+	}
+}
+
+// handleClaimVictory ends the game in the sender's favour once the
+// opponent's grace period is over
+func (r *Room) handleClaimVictory(sender *Client) {
+	if !r.isPlayer(sender) {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can claim the victory.")
+		return
+	}
+	d, ok := r.disconnections[sender.PlayerColor.Opponent()]
+	if !ok || !d.expired {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "The victory can only be claimed once the opponent's grace period is over.")
+		return
+	}
+	r.endGame(winFor(sender.PlayerColor), "abandonment")
+}
+
+// stopGraceTimers stops the timers of held seats when the game is over
+func (r *Room) stopGraceTimers() {
+	for color, d := range r.disconnections {
+		d.timer.Stop()
+		delete(r.disconnections, color)
+	}
+}
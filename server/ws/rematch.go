@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// resultAborted is the result of a game that was aborted. Aborted games are
+// not recorded.
+const resultAborted = "*"
+
+// GameOverPayload is broadcast as "game_over" when a game ends. The room then
+// stays open so that the players can play a rematch.
+type GameOverPayload struct {
+	Result      string `json:"result"` // "1-0", "0-1", "1/2-1/2" or "*" for aborted games
+	Termination string `json:"termination"`
+}
+
+// RematchPayload is broadcast as "rematch_offer" when a player offers a
+// rematch and as "rematch_declined" when the offer is declined
+type RematchPayload struct {
+	Color string `json:"color"` // The player who made the offer, in the finished game
+}
+
+// concludeGame announces the result of the game that just finished and
+// releases the seats of players who are not connected anymore
+func (r *Room) concludeGame(result string, termination string) {
+	r.result = result
+	r.termination = termination
+	r.rematchOffer = engine.NoColor
+
+	r.broadcastGameState()
+	r.broadcastMessage(Message{Action: "game_over", Payload: GameOverPayload{Result: result, Termination: termination}})
+	r.releaseHeldSeats()
+}
+
+// releaseHeldSeats lets the players whose connection dropped during the game
+// leave the room for good. A leaving host or ranked player closes the room,
+// so they go first.
+func (r *Room) releaseHeldSeats() {
+	var held []*Client
+	for _, p := range r.Players {
+		if p != nil && p.disconnected {
+			held = append(held, p)
+		}
+	}
+	for _, p := range held {
+		if p == r.Host || r.ReservedSeats {
+			r.handleClientUnregistration(p)
+			return
+		}
+	}
+	for _, p := range held {
+		r.handleClientUnregistration(p)
+	}
+}
+
+// handleOfferRematch offers the opponent another game. Offering a rematch
+// while the opponent's offer is pending accepts it.
+func (r *Room) handleOfferRematch(sender *Client) {
+	if !r.isPlayer(sender) {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can offer a rematch.")
+		return
+	}
+	switch r.rematchOffer {
+	case sender.PlayerColor:
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "You have already offered a rematch.")
+		return
+	case sender.PlayerColor.Opponent():
+		r.startRematch()
+		return
+	}
+
+	r.rematchOffer = sender.PlayerColor
+	r.broadcastMessage(Message{Action: "rematch_offer", Payload: RematchPayload{Color: colorName(r.rematchOffer)}})
+}
+
+// handleAcceptRematch starts the rematch the opponent offered
+func (r *Room) handleAcceptRematch(sender *Client) {
+	if !r.isPlayer(sender) || r.rematchOffer != sender.PlayerColor.Opponent() {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no rematch offer to accept.")
+		return
+	}
+	r.startRematch()
+}
+
+// handleDeclineRematch turns down the opponent's rematch offer
+func (r *Room) handleDeclineRematch(sender *Client) {
+	if !r.isPlayer(sender) || r.rematchOffer != sender.PlayerColor.Opponent() {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no rematch offer to decline.")
+		return
+	}
+	payload := RematchPayload{Color: colorName(r.rematchOffer)}
+	r.rematchOffer = engine.NoColor
+	r.broadcastMessage(Message{Action: "rematch_declined", Payload: payload})
+}
+
+// startRematch starts a new game between the same players with the colors
+// swapped and the same time control. A ranked rematch is rated like any other
+// ranked game.
+func (r *Room) startRematch() {
+	white, black := r.Players[engine.White], r.Players[engine.Black]
+	white.PlayerColor, black.PlayerColor = engine.Black, engine.White
+	r.Players = map[engine.Color]*Client{engine.White: black, engine.Black: white}
+
+	r.resetGame()
+	r.GameState = "in_progress"
+	log.Printf("Rematch started in room %s", r.ID)
+
+	r.announceColors()
+	r.startClock()
+	r.broadcastGameState()
+}
+
+// resetGame clears everything left from the previous game
+func (r *Room) resetGame() {
+	r.Game = engine.NewGame()
+	r.Moves = nil
+	r.Positions = nil
+	r.clock = nil
+	r.drawOffer = engine.NoColor
+	r.takebackRequest = engine.NoColor
+	r.premove = nil
+	r.rematchOffer = engine.NoColor
+	r.result = ""
+	r.termination = ""
+	r.saveChat()
+}
+
+// announceColors tells every player the color they play in the game that
+// starts
+func (r *Room) announceColors() {
+	for color, p := range r.Players {
+		if p == nil {
+			continue
+		}
+		message := Message{Action: "player_assigned", Payload: PlayerAssignmentPayload{Color: colorName(color)}}
+		messageBytes, _ := json.Marshal(message)
+		p.Send <- messageBytes
+	}
+}
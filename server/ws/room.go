@@ -2,11 +2,16 @@ package ws
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"math/rand"
+	"strings"
+	"time"
 
 	"github.com/TLeTu/Chess-Media/server/database"
 	"github.com/TLeTu/Chess-Media/server/engine"
+	"github.com/TLeTu/Chess-Media/server/models"
+	"github.com/TLeTu/Chess-Media/server/review"
 )
 
 type Room struct {
@@ -15,6 +20,7 @@ type Room struct {
 	Spectators map[*Client]bool
 	Host       *Client
 	GameState  string // "waiting", "in_progress", "finished"
+	closed     bool   // The room was removed from the hub
 	ReadyState map[*Client]bool
 
 	Broadcast  chan *ClientMessage
@@ -22,28 +28,80 @@ type Room struct {
 	Unregister chan *Client
 	Hub        *Hub
 	Game       *engine.Position
+	Moves      []engine.Move      // Moves played since the start of the game
+	Positions  []*engine.Position // Positions[i] is the position before Moves[i]
+
+	stateVersion uint64 // Version of the last game_state sent
 
 	IsRanked bool
 
-	PendingRankedPlayers map[uint]engine.Color // map[userID]assignedColor
+	// ReservedSeats rooms seat the players waiting in PendingPlayers as they
+	// connect and start the game once both are there, without a lobby. Ranked
+	// rooms and rooms of accepted seeks work this way, see lobby.go.
+	ReservedSeats bool
+
+	TimeControl *TimeControl // nil for untimed games
+	clock       *clock       // Runs while a timed game is in progress, see clock.go
+
+	drawOffer       engine.Color // The player whose draw offer is pending, see game_end.go
+	takebackRequest engine.Color // The player whose takeback request is pending, see takeback.go
+	premove         *premove     // Move queued by the player who is not to move, see premove.go
+
+	// Outcome of the finished game and the pending rematch offer, see rematch.go
+	result       string
+	termination  string
+	rematchOffer engine.Color
+
+	// Seats held for players who lost their connection, see reconnect.go
+	disconnections map[engine.Color]*disconnection
+	graceExpired   chan *disconnection
+
+	// Chat of the room, see chat.go
+	chatLog     []models.ChatMessage // Not saved yet
+	chatLimits  map[uint]*chatLimiter
+	muted       map[uint]bool
+	savedGameID uint // Last game of the room that was saved, 0 if none
+
+	PendingPlayers map[uint]engine.Color // map[userID]assignedColor
+
+	// The client message being handled, see protocol.go
+	request       *ClientMessage
+	requestFailed bool
+
+	// Progress and results of searches running outside the room goroutine,
+	// see engine_info.go
+	EngineUpdates chan *engineUpdate
+	analyses      map[*Client]chan struct{} // Stop channels of running analyses
 }
 
 func NewRoom(id string, hub *Hub, isRanked bool) *Room {
-	return &Room{
-		ID:                   id,
-		Players:              make(map[engine.Color]*Client),
-		Spectators:           make(map[*Client]bool),
-		Host:                 nil,
-		GameState:            "waiting",
-		ReadyState:           make(map[*Client]bool),
-		Broadcast:            make(chan *ClientMessage),
-		Register:             make(chan *Client),
-		Unregister:           make(chan *Client),
-		Hub:                  hub,
-		Game:                 engine.NewGame(),
-		IsRanked:             isRanked,
-		PendingRankedPlayers: make(map[uint]engine.Color),
-	}
+	room := &Room{
+		ID:             id,
+		Players:        make(map[engine.Color]*Client),
+		Spectators:     make(map[*Client]bool),
+		Host:           nil,
+		GameState:      "waiting",
+		ReadyState:     make(map[*Client]bool),
+		Broadcast:      make(chan *ClientMessage),
+		Register:       make(chan *Client),
+		Unregister:     make(chan *Client),
+		Hub:            hub,
+		Game:           engine.NewGame(),
+		IsRanked:       isRanked,
+		ReservedSeats:  isRanked,
+		PendingPlayers: make(map[uint]engine.Color),
+		EngineUpdates:  make(chan *engineUpdate, 64),
+		analyses:       make(map[*Client]chan struct{}),
+		disconnections: make(map[engine.Color]*disconnection),
+		graceExpired:   make(chan *disconnection, 2),
+		chatLimits:     make(map[uint]*chatLimiter),
+		muted:          make(map[uint]bool),
+	}
+	if isRanked {
+		tc := rankedTimeControl
+		room.TimeControl = &tc
+	}
+	return room
 }
 
 func (r *Room) getGuest() *Client {
@@ -55,10 +113,11 @@ func (r *Room) getGuest() *Client {
 	return nil
 }
 
+// getAllClients returns the clients that are connected to the room
 func (r *Room) getAllClients() map[*Client]bool {
 	all := make(map[*Client]bool)
 	for _, c := range r.Players {
-		if c != nil {
+		if c != nil && !c.disconnected {
 			all[c] = true
 		}
 	}
@@ -69,7 +128,7 @@ func (r *Room) getAllClients() map[*Client]bool {
 }
 
 func (r *Room) broadcastLobbyState() {
-	if r.IsRanked {
+	if r.ReservedSeats {
 		return
 	}
 	var hostReady, guestReady bool
@@ -85,8 +144,12 @@ func (r *Room) broadcastLobbyState() {
 			}
 		}
 	}
+	var guestIsBot bool
+	var botLevel int
 	if guest != nil {
 		guestReady = r.ReadyState[guest]
+		guestIsBot = guest.IsBot
+		botLevel = guest.BotLevel
 	}
 
 	for c := range r.getAllClients() {
@@ -99,6 +162,12 @@ func (r *Room) broadcastLobbyState() {
 			GameState:   r.GameState,
 			PlayerCount: len(r.Players),
 			GameType:    "unranked",
+			GuestIsBot:  guestIsBot,
+			BotLevel:    botLevel,
+		}
+		if r.TimeControl != nil {
+			tc := r.TimeControl.payload()
+			payload.TimeControl = &tc
 		}
 		message := Message{Action: "lobby_state", Payload: payload}
 		messageBytes, _ := json.Marshal(message)
@@ -106,12 +175,64 @@ func (r *Room) broadcastLobbyState() {
 	}
 }
 
-func (r *Room) broadcastGameState() {
+func (r *Room) gameStatePayload() GameStatePayload {
 	payload := GameStatePayload{
 		FEN:        r.Game.String(),
 		GameStatus: r.Game.GetGameStatus().String(),
+		Moves:      make([]string, len(r.Moves)),
+		SAN:        []string{},
+		InCheck:    engine.IsKingInCheck(r.Game, r.Game.Turn),
+		Captured:   CapturedPayload{White: []string{}, Black: []string{}},
+		Ply:        len(r.Moves),
+		Version:    r.stateVersion,
+	}
+	for i, move := range r.Moves {
+		payload.Moves[i] = move.String()
+
+		// The captured piece is read from the position the move was played in
+		before := r.Positions[i]
+		captured := before.Board[move.To]
+		if move.IsEnPassant {
+			captured = engine.WhitePawn
+			if before.Turn == engine.White {
+				captured = engine.BlackPawn
+			}
+		}
+		if captured != engine.Empty {
+			if before.Turn == engine.White {
+				payload.Captured.White = append(payload.Captured.White, captured.String())
+			} else {
+				payload.Captured.Black = append(payload.Captured.Black, captured.String())
+			}
+		}
+	}
+	if n := len(r.Moves); n > 0 {
+		payload.SAN = engine.MovesToSAN(r.Positions[0], r.Moves)
+		last := r.Moves[n-1]
+		payload.LastMove = &LastMovePayload{
+			From:      last.From.String(),
+			To:        last.To.String(),
+			Promotion: last.Promotion.String(),
+			UCI:       last.String(),
+			SAN:       payload.SAN[n-1],
+		}
 	}
-	message := Message{Action: "game_state", Payload: payload}
+	if r.clock != nil {
+		payload.Clock = r.clock.payload(time.Now())
+	}
+	payload.DrawOffer = colorName(r.drawOffer)
+	payload.Takeback = colorName(r.takebackRequest)
+	if r.GameState == "finished" {
+		payload.Result = r.result
+		payload.Termination = r.termination
+		payload.Rematch = colorName(r.rematchOffer)
+	}
+	return payload
+}
+
+func (r *Room) broadcastGameState() {
+	r.stateVersion++
+	message := Message{Action: "game_state", Payload: r.gameStatePayload()}
 	messageBytes, _ := json.Marshal(message)
 
 	for client := range r.getAllClients() {
@@ -119,23 +240,25 @@ func (r *Room) broadcastGameState() {
 	}
 }
 
-func (r *Room) sendErrorMessage(client *Client, message string) {
-	payload := ErrorPayload{Message: message}
+// sendErrorMessage tells a client why its request failed. The error answers
+// the request being handled if the client sent it.
+func (r *Room) sendErrorMessage(client *Client, code string, message string) {
+	payload := ErrorPayload{Code: code, Message: message}
 	msg := Message{Action: "error", Payload: payload}
+	if r.request != nil && r.request.Client == client {
+		msg.RequestID = r.request.Message.RequestID
+		r.requestFailed = true
+	}
 	messageBytes, _ := json.Marshal(msg)
 	client.Send <- messageBytes
 }
 
-func (r *Room) handleAssignColor(sender *Client, payload interface{}) {
+func (r *Room) handleAssignColor(sender *Client, colorPayload *AssignColorPayload) {
 	if sender != r.Host {
-		r.sendErrorMessage(sender, "Only the host can assign colors.")
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only the host can assign colors.")
 		return
 	}
 
-	payloadBytes, _ := json.Marshal(payload)
-	var colorPayload AssignColorPayload
-	json.Unmarshal(payloadBytes, &colorPayload)
-
 	guest := r.getGuest()
 	var hostC, guestC engine.Color
 	switch colorPayload.Color {
@@ -150,7 +273,7 @@ func (r *Room) handleAssignColor(sender *Client, payload interface{}) {
 			hostC, guestC = engine.Black, engine.White
 		}
 	default:
-		r.sendErrorMessage(sender, "Invalid color selection.")
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, "Invalid color selection.")
 		return
 	}
 
@@ -169,6 +292,63 @@ func (r *Room) handleAssignColor(sender *Client, payload interface{}) {
 	r.broadcastLobbyState()
 }
 
+// handleAddBot seats the computer as the guest. The bot is always ready.
+func (r *Room) handleAddBot(sender *Client, botPayload *AddBotPayload) {
+	if sender != r.Host {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only the host can add a computer opponent.")
+		return
+	}
+	if r.getGuest() != nil {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "The room already has two players.")
+		return
+	}
+
+	botClient, err := newBotClient(r, botPayload.Level, botPayload.Personality)
+	if err != nil {
+		r.sendErrorMessage(sender, ErrCodeInvalidPayload, "Invalid computer opponent: "+err.Error())
+		return
+	}
+
+	// Seat the bot like a guest joining, taking the host's opposite color if
+	// one was already assigned
+	botKey := engine.Color(10 + len(r.Players))
+	switch r.Host.PlayerColor {
+	case engine.White:
+		botKey = engine.Black
+	case engine.Black:
+		botKey = engine.White
+	}
+	if botKey == engine.White || botKey == engine.Black {
+		botClient.PlayerColor = botKey
+	}
+	botClient.ShowThinking = botPayload.ShowThinking
+	r.Players[botKey] = botClient
+	r.ReadyState[botClient] = true
+
+	log.Printf("Bot level %d added to room %s", botClient.BotLevel, r.ID)
+	r.broadcastLobbyState()
+}
+
+// handleRemoveBot takes the computer opponent out of the room
+func (r *Room) handleRemoveBot(sender *Client) {
+	if sender != r.Host {
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only the host can remove the computer opponent.")
+		return
+	}
+	guest := r.getGuest()
+	if guest == nil || !guest.IsBot {
+		return
+	}
+	for color, p := range r.Players {
+		if p == guest {
+			delete(r.Players, color)
+		}
+	}
+	delete(r.ReadyState, guest)
+	close(guest.Send)
+	r.broadcastLobbyState()
+}
+
 func (r *Room) handlePlayerReady(sender *Client) {
 	if r.GameState != "waiting" {
 		return
@@ -179,39 +359,26 @@ func (r *Room) handlePlayerReady(sender *Client) {
 
 func (r *Room) handleStartGame(sender *Client) {
 	if sender != r.Host {
-		r.sendErrorMessage(sender, "Only the host can start the game.")
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only the host can start the game.")
 		return
 	}
 	guest := r.getGuest()
 	if guest == nil {
-		r.sendErrorMessage(sender, "Two players are required to start.")
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "Two players are required to start.")
 		return
 	}
 	if !r.ReadyState[guest] {
-		r.sendErrorMessage(sender, "Guest must be ready.")
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "Guest must be ready.")
 		return
 	}
 	if r.Host.PlayerColor == engine.NoColor || guest.PlayerColor == engine.NoColor {
-		r.sendErrorMessage(sender, "The host must select a color first.")
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "The host must select a color first.")
 		return
 	}
 
 	r.GameState = "in_progress"
-	for color, client := range r.Players {
-		if client != nil {
-			var colorStr string
-			if color == engine.White {
-				colorStr = "white"
-			} else if color == engine.Black {
-				colorStr = "black"
-			}
-			payload := PlayerAssignmentPayload{Color: colorStr}
-			message := Message{Action: "player_assigned", Payload: payload}
-			messageBytes, _ := json.Marshal(message)
-			client.Send <- messageBytes
-		}
-	}
-
+	r.announceColors()
+	r.startClock()
 	r.broadcastGameState()
 }
 
@@ -224,66 +391,142 @@ func (r *Room) Run() {
 		case client := <-r.Unregister:
 			r.handleClientUnregistration(client)
 
+		case update := <-r.EngineUpdates:
+			r.handleEngineUpdate(update)
+
+		case <-r.clock.expired():
+			r.handleFlag()
+
+		case d := <-r.graceExpired:
+			r.handleGraceExpired(d)
+
 		case clientMessage := <-r.Broadcast:
-			sender := clientMessage.Client
-			message := clientMessage.Message
-
-			if r.GameState == "waiting" && !r.IsRanked {
-				switch message.Action {
-				case "assign_color":
-					r.handleAssignColor(sender, message.Payload)
-				case "player_ready":
-					r.handlePlayerReady(sender)
-				case "start_game":
-					r.handleStartGame(sender)
-				default:
-					log.Printf("Action '%s' not allowed during 'waiting' state.", message.Action)
-				}
-			} else if r.GameState == "in_progress" {
-				if message.Action == "move" {
-					r.handleMove(sender, message.Payload)
-				} else {
-					log.Printf("Action '%s' not allowed during 'in_progress' state.", message.Action)
-				}
-			}
+			r.handleClientMessage(clientMessage)
 		}
 	}
 }
 
+// dispatch carries out the action of a client message. Each action is only
+// available in some states of the room, see clientPayloads.
+func (r *Room) dispatch(sender *Client, message *Message) {
+	// Searches and the chat are available in any state
+	switch message.Action {
+	case "analyze":
+		r.handleAnalyze(sender, message.Payload.(*AnalyzePayload))
+		return
+	case "stop_search":
+		r.handleStopSearch(sender)
+		return
+	case "chat":
+		r.handleChat(sender, message.Payload.(*ChatPayload))
+		return
+	case "mute", "unmute":
+		r.handleMute(sender, message.Payload.(*MutePayload), message.Action == "mute")
+		return
+	}
+
+	state := r.GameState
+	if state == "waiting" && !r.ReservedSeats {
+		switch message.Action {
+		case "assign_color":
+			r.handleAssignColor(sender, message.Payload.(*AssignColorPayload))
+			return
+		case "player_ready":
+			r.handlePlayerReady(sender)
+			return
+		case "start_game":
+			r.handleStartGame(sender)
+			return
+		case "add_bot":
+			r.handleAddBot(sender, message.Payload.(*AddBotPayload))
+			return
+		case "remove_bot":
+			r.handleRemoveBot(sender)
+			return
+		case "set_time_control":
+			r.handleSetTimeControl(sender, message.Payload.(*TimeControlPayload))
+			return
+		}
+	} else if state == "in_progress" {
+		switch message.Action {
+		case "move":
+			r.handleMove(sender, message.Payload.(*MovePayload))
+			return
+		case "premove":
+			r.handlePremove(sender, message.Payload.(*MovePayload))
+			return
+		case "cancel_premove":
+			r.handleCancelPremove(sender)
+			return
+		case "resign":
+			r.handleResign(sender)
+			return
+		case "offer_draw":
+			r.handleOfferDraw(sender)
+			return
+		case "accept_draw":
+			r.handleAcceptDraw(sender)
+			return
+		case "decline_draw":
+			r.handleDeclineDraw(sender)
+			return
+		case "abort":
+			r.handleAbort(sender)
+			return
+		case "request_takeback":
+			r.handleRequestTakeback(sender)
+			return
+		case "accept_takeback":
+			r.handleAcceptTakeback(sender)
+			return
+		case "decline_takeback":
+			r.handleDeclineTakeback(sender)
+			return
+		case "claim_victory":
+			r.handleClaimVictory(sender)
+			return
+		}
+	} else if state == "finished" {
+		switch message.Action {
+		case "offer_rematch":
+			r.handleOfferRematch(sender)
+			return
+		case "accept_rematch":
+			r.handleAcceptRematch(sender)
+			return
+		case "decline_rematch":
+			r.handleDeclineRematch(sender)
+			return
+		}
+	}
+	r.sendErrorMessage(sender, ErrCodeInvalidState, fmt.Sprintf("Action '%s' is not allowed while the room is %s.", message.Action, state))
+}
+
 func (r *Room) handleClientRegistration(client *Client) {
 	client.Room = r
+	if r.reattach(client) {
+		return
+	}
 
-	if r.IsRanked {
-		assignedColor, ok := r.PendingRankedPlayers[client.UserID]
+	if r.ReservedSeats {
+		assignedColor, ok := r.PendingPlayers[client.UserID]
 		if !ok {
-			log.Printf("Error: Ranked client %d connected without pending data.", client.UserID)
-			r.sendErrorMessage(client, "Error: Could not join ranked game. Missing player data.")
+			log.Printf("Error: Client %d connected to room %s without a reserved seat.", client.UserID, r.ID)
+			r.sendErrorMessage(client, ErrCodeForbidden, "Error: Could not join the game. Missing player data.")
 			close(client.Send)
 			return
 		}
 
 		client.PlayerColor = assignedColor
 		r.Players[assignedColor] = client
-		delete(r.PendingRankedPlayers, client.UserID)
+		delete(r.PendingPlayers, client.UserID)
 
-		log.Printf("Client %d registered to ranked room %s. Color: %s", client.UserID, r.ID, client.PlayerColor.String())
+		log.Printf("Client %d registered to room %s with a reserved seat. Color: %s", client.UserID, r.ID, client.PlayerColor.String())
 
 		if len(r.Players) == 2 {
 			r.GameState = "in_progress"
-			for color, p := range r.Players {
-				if p != nil {
-					var colorStr string
-					if color == engine.White {
-						colorStr = "white"
-					} else if color == engine.Black {
-						colorStr = "black"
-					}
-					payload := PlayerAssignmentPayload{Color: colorStr}
-					message := Message{Action: "player_assigned", Payload: payload}
-					messageBytes, _ := json.Marshal(message)
-					p.Send <- messageBytes
-				}
-			}
+			r.announceColors()
+			r.startClock()
 			r.broadcastGameState()
 		}
 		return
@@ -307,35 +550,45 @@ func (r *Room) handleClientRegistration(client *Client) {
 }
 
 func (r *Room) handleClientUnregistration(client *Client) {
-	if r.IsRanked {
-		log.Printf("Client %d unregistered from ranked room %s.", client.UserID, r.ID)
-		for _, p := range r.Players {
-			if p != nil && p != client {
-				r.sendErrorMessage(p, "Opponent disconnected. Game ended.")
-				close(p.Send)
-			}
-		}
-		for s := range r.Spectators {
-			r.sendErrorMessage(s, "Game ended due to player disconnection.")
-			close(s.Send)
+	// Connections replaced by a reconnection are already gone
+	if !r.isMember(client) {
+		return
+	}
+	r.stopAnalysis(client)
+
+	// A player dropping out of a game may come back
+	if r.GameState == "in_progress" && r.isPlayer(client) {
+		r.holdSeat(client)
+		return
+	}
+
+	if r.ReservedSeats && r.isPlayer(client) {
+		log.Printf("Client %d unregistered from room %s.", client.UserID, r.ID)
+		reason := "Opponent disconnected. Game ended."
+		if r.GameState == "finished" {
+			reason = "Your opponent left the room."
 		}
-		r.Hub.deleteRoom(r.ID)
+		r.finish()
+		client.disconnected = true
+		r.closeRoom(reason)
 		return
 	}
 
 	// Unranked game logic
 	if client == r.Host {
 		log.Printf("Host disconnected from room %s. Closing room.", r.ID)
-		for c := range r.getAllClients() {
-			if c != client {
-				r.sendErrorMessage(c, "The host has disconnected. The game has ended.")
-				close(c.Send)
-			}
-		}
-		r.Hub.deleteRoom(r.ID)
+		r.finish()
+		client.disconnected = true
+		r.closeRoom("The host has disconnected. The game has ended.")
 		return
 	}
 
+	// Once a guest has left after a game, the host waits for a new one
+	if r.GameState == "finished" && r.isPlayer(client) {
+		r.resetGame()
+		r.GameState = "waiting"
+	}
+
 	delete(r.Spectators, client)
 	var colorToDelete engine.Color = -1
 	for color, p := range r.Players {
@@ -353,77 +606,152 @@ func (r *Room) handleClientUnregistration(client *Client) {
 
 	log.Printf("Client unregistered from room %s. Players: %d, Spectators: %d", r.ID, len(r.Players), len(r.Spectators))
 	if len(r.Players) == 0 && len(r.Spectators) == 0 {
+		r.saveChat()
+		r.closed = true
 		r.Hub.deleteRoom(r.ID)
 		return
 	}
 	r.broadcastLobbyState()
 }
 
-func (r *Room) handleMove(sender *Client, payload interface{}) {
+func (r *Room) handleMove(sender *Client, movePayload *MovePayload) {
 	if sender.PlayerColor == engine.NoColor {
-		r.sendErrorMessage(sender, "Spectators cannot make moves.")
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Spectators cannot make moves.")
 		return
 	}
 	if sender.PlayerColor != r.Game.Turn {
-		r.sendErrorMessage(sender, "It's not your turn.")
+		r.sendErrorMessage(sender, ErrCodeNotYourTurn, "It's not your turn.")
 		return
 	}
-	payloadBytes, _ := json.Marshal(payload)
-	var movePayload MovePayload
-	json.Unmarshal(payloadBytes, &movePayload)
 	moveStr := movePayload.From + movePayload.To
 	if movePayload.Promotion != "" {
 		moveStr += movePayload.Promotion
 	}
 	move, err := engine.ParseMove(r.Game, moveStr)
 	if err != nil {
-		r.sendErrorMessage(sender, "Invalid move: "+err.Error())
+		r.sendErrorMessage(sender, ErrCodeIllegalMove, "Invalid move: "+err.Error())
 		return
 	}
+	r.playMove(move, false)
+}
+
+// playMove plays a legal move of the side to move, then the premove of the
+// opponent if there is one, see premove.go
+func (r *Room) playMove(move engine.Move, premove bool) {
+	color := r.Game.Turn
+	if r.clock != nil {
+		var pressed bool
+		if premove {
+			pressed = r.clock.pressPremove(time.Now())
+		} else {
+			pressed = r.clock.press(time.Now())
+		}
+		if !pressed {
+			// The move arrived after the flag fell
+			r.handleFlag()
+			return
+		}
+	}
+	r.Positions = append(r.Positions, r.Game)
 	r.Game = engine.ApplyMove(r.Game, move)
+	r.Moves = append(r.Moves, move)
+
+	// Playing on declines the opponent's draw offer and takeback request
+	if r.drawOffer == color.Opponent() {
+		r.cancelDrawOffer()
+	}
+	if r.takebackRequest == color.Opponent() {
+		r.cancelTakeback()
+	}
 
 	gameStatus := r.Game.GetGameStatus()
 	if gameStatus != engine.InProgress {
-		log.Printf("Game %s ended with status: %s", r.ID, gameStatus.String())
-
-		if r.IsRanked {
-			var winner, loser *Client
-			if gameStatus == engine.Checkmate {
-				winner = r.Players[r.Game.Turn.Opponent()]
-				loser = r.Players[r.Game.Turn]
-			} else {
-				log.Printf("Ranked game %s ended in a draw. No ELO changes.", r.ID)
+		result := models.ResultDraw
+		if gameStatus == engine.Checkmate {
+			result = models.ResultWhiteWins
+			if r.Game.Turn == engine.White {
+				result = models.ResultBlackWins
 			}
+		}
+		r.endGame(result, gameStatus.String())
+		return
+	}
 
-			if winner != nil && loser != nil {
-				winner.UserELO += 100
-				loser.UserELO -= 50
+	r.broadcastGameState()
+	r.playPremove()
+}
 
-				if err := database.UpdateUserELO(winner.User.ID, winner.UserELO); err != nil {
-					log.Printf("Error updating ELO for winner %d: %v", winner.UserID, err)
-				}
-				if err := database.UpdateUserELO(loser.User.ID, loser.UserELO); err != nil {
-					log.Printf("Error updating ELO for loser %d: %v", loser.UserID, err)
-				}
+// endGame records the result of the game, updates ranked ratings, schedules
+// the game review and opens the post-game phase
+func (r *Room) endGame(result string, termination string) {
+	log.Printf("Game %s ended with result %s (%s)", r.ID, result, termination)
+	r.finish()
 
-				log.Printf("ELO updated: Winner %d (New ELO: %d), Loser %d (New ELO: %d)",
-					winner.UserID, winner.UserELO, loser.UserID, loser.UserELO)
-			}
+	if r.IsRanked {
+		var winner, loser *Client
+		switch result {
+		case models.ResultWhiteWins:
+			winner, loser = r.Players[engine.White], r.Players[engine.Black]
+		case models.ResultBlackWins:
+			winner, loser = r.Players[engine.Black], r.Players[engine.White]
+		default:
+			log.Printf("Ranked game %s ended in a draw. No ELO changes.", r.ID)
 		}
 
-		for _, p := range r.Players {
-			if p != nil {
-				r.sendErrorMessage(p, "Game Over: "+gameStatus.String())
-				close(p.Send)
+		if winner != nil && loser != nil {
+			winner.UserELO += 100
+			loser.UserELO -= 50
+
+			if err := database.UpdateUserELO(winner.User.ID, winner.UserELO); err != nil {
+				log.Printf("Error updating ELO for winner %d: %v", winner.UserID, err)
 			}
+			if err := database.UpdateUserELO(loser.User.ID, loser.UserELO); err != nil {
+				log.Printf("Error updating ELO for loser %d: %v", loser.UserID, err)
+			}
+
+			log.Printf("ELO updated: Winner %d (New ELO: %d), Loser %d (New ELO: %d)",
+				winner.UserID, winner.UserELO, loser.UserID, loser.UserELO)
 		}
-		for s := range r.Spectators {
-			r.sendErrorMessage(s, "Game Over: "+gameStatus.String())
-			close(s.Send)
-		}
-		r.Hub.deleteRoom(r.ID)
+	}
+
+	r.saveGame(result, termination)
+	r.concludeGame(result, termination)
+}
+
+// saveGame stores the finished game and queues its review
+func (r *Room) saveGame(result string, termination string) {
+	moves := make([]string, len(r.Moves))
+	for i, move := range r.Moves {
+		moves[i] = move.String()
+	}
+
+	game := &models.Game{
+		Moves:       strings.Join(moves, " "),
+		Result:      result,
+		Termination: termination,
+		Ranked:      r.IsRanked,
+	}
+	if white := r.Players[engine.White]; white != nil {
+		game.WhiteID = white.UserID
+	}
+	if black := r.Players[engine.Black]; black != nil {
+		game.BlackID = black.UserID
+	}
+	if guest := r.getGuest(); guest != nil && guest.IsBot {
+		game.BotLevel = guest.BotLevel
+		game.BotPersonality = guest.BotPersonality
+	}
+
+	if err := database.SaveGame(game); err != nil {
+		log.Printf("Error saving game of room %s: %v", r.ID, err)
 		return
 	}
+	log.Printf("Game of room %s saved with ID %d", r.ID, game.ID)
 
-	r.broadcastGameState()
+	r.savedGameID = game.ID
+	r.saveChat()
+
+	if len(r.Moves) > 0 {
+		review.Enqueue(game.ID)
+	}
 }
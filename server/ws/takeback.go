@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"github.com/TLeTu/Chess-Media/server/engine"
+)
+
+// TakebackPayload is broadcast as "takeback_request" when a player asks to
+// take back their last move and as "takeback_declined" when the request is
+// declined or cancelled by a move
+type TakebackPayload struct {
+	Color string `json:"color"` // The player who asked
+}
+
+// takebackPlies is the number of plies to undo so that a player is to move
+// again, or 0 if they have not moved yet
+func (r *Room) takebackPlies(color engine.Color) int {
+	plies := 1
+	if r.Game.Turn == color {
+		plies = 2
+	}
+	if plies > len(r.Moves) {
+		return 0
+	}
+	return plies
+}
+
+// handleRequestTakeback asks the opponent to take back the sender's last
+// move. Takebacks are only allowed in unranked games.
+func (r *Room) handleRequestTakeback(sender *Client) {
+	switch {
+	case r.IsRanked:
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Takebacks are not allowed in ranked games.")
+		return
+	case !r.isPlayer(sender):
+		r.sendErrorMessage(sender, ErrCodeForbidden, "Only players can ask for a takeback.")
+		return
+	case r.takebackRequest == sender.PlayerColor:
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "You have already asked for a takeback.")
+		return
+	case r.takebackPlies(sender.PlayerColor) == 0:
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no move to take back.")
+		return
+	}
+
+	r.takebackRequest = sender.PlayerColor
+	r.broadcastMessage(Message{Action: "takeback_request", Payload: TakebackPayload{Color: colorName(r.takebackRequest)}})
+}
+
+// handleAcceptTakeback rolls the game back to the opponent's turn before
+// their last move
+func (r *Room) handleAcceptTakeback(sender *Client) {
+	if !r.isPlayer(sender) || r.takebackRequest != sender.PlayerColor.Opponent() {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no takeback request to accept.")
+		return
+	}
+	requester := r.takebackRequest
+	r.takebackRequest = engine.NoColor
+
+	plies := r.takebackPlies(requester)
+	if plies == 0 {
+		return
+	}
+	if r.clock != nil && !r.clock.handOver(requester, time.Now()) {
+		r.handleFlag()
+		return
+	}
+
+	n := len(r.Moves) - plies
+	r.Game = r.Positions[n]
+	r.Moves = r.Moves[:n]
+	r.Positions = r.Positions[:n]
+	r.drawOffer = engine.NoColor
+	r.premove = nil
+
+	log.Printf("Took back %d plies in room %s", plies, r.ID)
+	r.broadcastGameState()
+}
+
+// handleDeclineTakeback turns down the opponent's takeback request
+func (r *Room) handleDeclineTakeback(sender *Client) {
+	if !r.isPlayer(sender) || r.takebackRequest != sender.PlayerColor.Opponent() {
+		r.sendErrorMessage(sender, ErrCodeInvalidState, "There is no takeback request to decline.")
+		return
+	}
+	r.cancelTakeback()
+}
+
+// cancelTakeback withdraws the pending takeback request, if any
+func (r *Room) cancelTakeback() {
+	if r.takebackRequest == engine.NoColor {
+		return
+	}
+	payload := TakebackPayload{Color: colorName(r.takebackRequest)}
+	r.takebackRequest = engine.NoColor
+	r.broadcastMessage(Message{Action: "takeback_declined", Payload: payload})
+}